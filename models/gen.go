@@ -0,0 +1,39 @@
+//go:build ignore
+// +build ignore
+
+// gen trains the language models embedded in the package.
+// Run it from the root of the repository with go generate
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/shxdow/matasano"
+)
+
+var corpora = []struct {
+	language, filename string
+}{
+	{"english", "_testdata/aliceinwonderland.txt"},
+}
+
+func main() {
+	for _, c := range corpora {
+		m, err := matasano.TrainCorpusModelFile(c.language, c.filename, 3)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		f, err := os.Create("models/" + c.language + ".model")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := m.Save(f); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -2,16 +2,41 @@
 package matasano
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/heap"
+	"context"
 	"crypto/aes"
+	"crypto/cipher"
+	"embed"
 	"encoding/base64"
+	"encoding/gob"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"unicode/utf8"
 )
 
+var (
+	// ErrLengthMismatch is returned when two buffers
+	// that should have the same length do not
+	ErrLengthMismatch = errors.New("different length buffers")
+	// ErrInvalidHex is returned for malformed hex strings
+	ErrInvalidHex = errors.New("invalid hex")
+	// ErrKeySize is returned for keys a cipher cannot use
+	ErrKeySize = errors.New("invalid key size")
+)
+
 func Toggle(in []byte) []byte {
 	r := make([]byte, len(in))
 	for i := 0; i < len(in); i++ {
@@ -34,15 +59,14 @@ func hasBit(n byte, pos uint) bool {
 }
 
 // challenge 1
-func HexToBase64(s string) string {
+func HexToBase64(s string) (string, error) {
 
 	r, err := hex.DecodeString(s)
 	if err != nil {
-		log.Fatal(err)
+		return "", fmt.Errorf("%w: %v", ErrInvalidHex, err)
 	}
 
-	log.Printf("%s", r)
-	return base64.StdEncoding.EncodeToString(r)
+	return base64.StdEncoding.EncodeToString(r), nil
 }
 
 // Function Xor performs bitwise xor between two byte arrays.
@@ -51,9 +75,7 @@ func HexToBase64(s string) string {
 func Xor(s1, s2 []byte) ([]byte, error) {
 
 	if len(s1) != len(s2) {
-		log.Printf("s1: %v, len s1: %d", s1, len(s1))
-		log.Printf("s2: %v, len s2: %d", s2, len(s2))
-		log.Fatal("Different length buffers\n")
+		return nil, ErrLengthMismatch
 	}
 
 	res := make([]byte, len(s1))
@@ -115,6 +137,407 @@ func ScoreEnglish(text string, freq map[rune]float64) float64 {
 	return score
 }
 
+// Scorer rates how much a text looks like the language it was
+// trained on. Higher scores mean more likely plain text
+type Scorer interface {
+	Score(text []byte) float64
+}
+
+// FreqScorer is the weighted sum of ScoreEnglish, fed with
+// the frequencies returned by AnalyzeCorpus
+type FreqScorer map[rune]float64
+
+// Score implements Scorer
+func (f FreqScorer) Score(text []byte) float64 {
+	return ScoreEnglish(string(text), f)
+}
+
+// ChiSquaredScorer compares the letter distribution of a text with
+// the one of the corpus. Unlike the weighted sum, a text made of nothing
+// but spaces and e's scores poorly: every class counts, both the ones
+// showing up too often and the ones missing. Letters are case folded and
+// the rest lumped into a few classes, so that the quirks of the corpus
+// (say curly quotes or CRLF line endings) don't leak into the model
+type ChiSquaredScorer struct {
+	freq [chiClasses]float64
+}
+
+// The classes ChiSquaredScorer counts: 26 letters,
+// then space, other printables, white space and the rest
+const (
+	chiSpace = 26 + iota
+	chiPrintable
+	chiWhite
+	chiOther
+	chiClasses
+)
+
+// chiClass returns the class of b
+func chiClass(b byte) int {
+	switch {
+	case b >= 'a' && b <= 'z':
+		return int(b - 'a')
+	case b >= 'A' && b <= 'Z':
+		return int(b - 'A')
+	case b == ' ':
+		return chiSpace
+	case b > 0x20 && b < 0x7f:
+		return chiPrintable
+	case b == '\n' || b == '\r' || b == '\t':
+		return chiWhite
+	}
+	return chiOther
+}
+
+// NewChiSquaredScorer learns the class frequencies of corpus. Every
+// class gets one occurrence for free, so unseen ones are unlikely
+// rather than impossible
+func NewChiSquaredScorer(corpus string) *ChiSquaredScorer {
+
+	var counts [chiClasses]float64
+	for i := 0; i < len(corpus); i++ {
+		counts[chiClass(corpus[i])]++
+	}
+
+	c := &ChiSquaredScorer{}
+	total := float64(len(corpus)) + chiClasses
+	for i := range counts {
+		c.freq[i] = (counts[i] + 1) / total
+	}
+
+	return c
+}
+
+// Score returns the chi-squared statistic, negated so that higher is
+// better and divided by the length of text so that texts of different
+// lengths compare
+func (c *ChiSquaredScorer) Score(text []byte) float64 {
+
+	if len(text) == 0 {
+		return 0
+	}
+
+	var counts [chiClasses]float64
+	for _, b := range text {
+		counts[chiClass(b)]++
+	}
+
+	n := float64(len(text))
+	var chi float64
+	for i := range counts {
+		exp := c.freq[i] * n
+		d := counts[i] - exp
+		chi += d * d / exp
+	}
+
+	return -chi / n
+}
+
+// NGramScorer is a character level n-gram model: the score of a text
+// is the average log probability of each byte given the n-1 before it.
+// Bigrams and trigrams only make sense on contiguous text, the columns
+// of a repeating key xor are better served by ChiSquaredScorer
+type NGramScorer struct {
+	n        int
+	grams    map[string]float64
+	contexts map[string]float64
+}
+
+// NewNGramScorer counts the n-grams of corpus, n is at least 2
+func NewNGramScorer(corpus string, n int) (*NGramScorer, error) {
+
+	if n < 2 {
+		return nil, errors.New("n-grams need n of at least 2")
+	}
+	if len(corpus) < n {
+		return nil, errors.New("corpus shorter than n")
+	}
+
+	g := &NGramScorer{
+		n:        n,
+		grams:    make(map[string]float64),
+		contexts: make(map[string]float64),
+	}
+	for i := 0; i+n <= len(corpus); i++ {
+		g.grams[corpus[i:i+n]]++
+		g.contexts[corpus[i:i+n-1]]++
+	}
+
+	return g, nil
+}
+
+// Score returns the average log probability per n-gram, smoothed by
+// adding one to every count. Texts shorter than n get the score of
+// uniformly random bytes
+func (g *NGramScorer) Score(text []byte) float64 {
+
+	if len(text) < g.n {
+		return math.Log(1.0 / 256)
+	}
+
+	var sum float64
+	for i := 0; i+g.n <= len(text); i++ {
+		gram := string(text[i : i+g.n])
+		p := (g.grams[gram] + 1) / (g.contexts[gram[:g.n-1]] + 256)
+		sum += math.Log(p)
+	}
+
+	return sum / float64(len(text)-g.n+1)
+}
+
+// PrintableScorer returns the share of printable ASCII bytes,
+// white space included
+type PrintableScorer struct{}
+
+// Score implements Scorer
+func (PrintableScorer) Score(text []byte) float64 {
+
+	if len(text) == 0 {
+		return 1
+	}
+
+	printable := 0
+	for _, b := range text {
+		if (b >= 0x20 && b < 0x7f) || b == '\n' || b == '\r' || b == '\t' {
+			printable++
+		}
+	}
+
+	return float64(printable) / float64(len(text))
+}
+
+// CombinedScorer sums the scores of several models, each with its
+// weight. Texts whose printable ratio is below MinPrintable are ruled
+// out before any model gets to see them
+type CombinedScorer struct {
+	Scorers      []Scorer
+	Weights      []float64
+	MinPrintable float64
+}
+
+// Score implements Scorer
+func (c *CombinedScorer) Score(text []byte) float64 {
+
+	if (PrintableScorer{}).Score(text) < c.MinPrintable {
+		return math.Inf(-1)
+	}
+
+	var score float64
+	for i, s := range c.Scorers {
+		score += c.Weights[i] * s.Score(text)
+	}
+
+	return score
+}
+
+// NewEnglishScorer returns the combined model trained on corpus: the
+// unigram chi-squared statistic along with trigram log probabilities,
+// for text that's at least 90% printable
+func NewEnglishScorer(corpus string) (*CombinedScorer, error) {
+
+	trigrams, err := NewNGramScorer(corpus, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CombinedScorer{
+		Scorers:      []Scorer{NewChiSquaredScorer(corpus), trigrams},
+		Weights:      []float64{1, 1},
+		MinPrintable: 0.9,
+	}, nil
+}
+
+// The detectors below rate how confident they are, between 0 and 1,
+// that a text is of their kind. Unlike the language models, their
+// scores compare with each other, which is what AutoScorer relies on
+
+// LanguageDetector rates how much a text looks like the language of m:
+// the share of printable bytes, times e to the (per byte) chi-squared
+// score, which is 1 for a perfect match
+func LanguageDetector(m *CorpusModel) Scorer {
+	return &languageDetector{chi: m.ChiSquaredScorer()}
+}
+
+type languageDetector struct {
+	chi *ChiSquaredScorer
+}
+
+// Score implements Scorer
+func (l *languageDetector) Score(text []byte) float64 {
+	return PrintableScorer{}.Score(text) * math.Exp(l.chi.Score(text))
+}
+
+// JSONScorer returns 1 for valid JSON documents, 0 otherwise
+type JSONScorer struct{}
+
+// Score implements Scorer
+func (JSONScorer) Score(text []byte) float64 {
+	if json.Valid(text) {
+		return 1
+	}
+	return 0
+}
+
+// HexScorer returns 1 when the whole text decodes as hex, 0 otherwise
+type HexScorer struct{}
+
+// Score implements Scorer
+func (HexScorer) Score(text []byte) float64 {
+	if _, err := hex.DecodeString(string(text)); err == nil && len(text) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Base64Scorer returns 0.95 when the text decodes as standard base64,
+// line breaks aside, 0 otherwise. Hex digits are valid base64 too:
+// scoring a bit less lets the stricter of the two win
+type Base64Scorer struct{}
+
+// Score implements Scorer
+func (Base64Scorer) Score(text []byte) float64 {
+	stripped := strings.NewReplacer("\r", "", "\n", "").Replace(string(text))
+	if _, err := base64.StdEncoding.DecodeString(stripped); err == nil && len(stripped) > 0 {
+		return 0.95
+	}
+	return 0
+}
+
+// UTF16Scorer rates text as UTF-16 encoded ASCII, either endianness:
+// the share of code units holding a printable character
+type UTF16Scorer struct{}
+
+// Score implements Scorer
+func (UTF16Scorer) Score(text []byte) float64 {
+
+	if len(text) < 2 || len(text)%2 != 0 {
+		return 0
+	}
+
+	var le, be int
+	for i := 0; i < len(text); i += 2 {
+		if text[i+1] == 0 && (PrintableScorer{}).Score(text[i:i+1]) == 1 {
+			le++
+		}
+		if text[i] == 0 && (PrintableScorer{}).Score(text[i+1:i+2]) == 1 {
+			be++
+		}
+	}
+	if be > le {
+		le = be
+	}
+
+	return float64(le) / float64(len(text)/2)
+}
+
+// fileMagics are the first bytes of some common file formats
+var fileMagics = []struct {
+	name  string
+	magic []byte
+}{
+	{"png", []byte("\x89PNG\r\n\x1a\n")},
+	{"jpeg", []byte("\xff\xd8\xff")},
+	{"gif", []byte("GIF8")},
+	{"pdf", []byte("%PDF-")},
+	{"zip", []byte("PK\x03\x04")},
+	{"gzip", []byte("\x1f\x8b\x08")},
+	{"elf", []byte("\x7fELF")},
+	{"pe", []byte("MZ")},
+	{"bzip2", []byte("BZh")},
+}
+
+// FileMagic returns the format whose magic bytes text starts
+// with, if any
+func FileMagic(text []byte) (string, bool) {
+	for _, m := range fileMagics {
+		if bytes.HasPrefix(text, m.magic) {
+			return m.name, true
+		}
+	}
+	return "", false
+}
+
+// MagicScorer returns 1 for texts starting with known magic bytes.
+// Short magics are more likely by chance, so they score a bit less
+type MagicScorer struct{}
+
+// Score implements Scorer
+func (MagicScorer) Score(text []byte) float64 {
+	for _, m := range fileMagics {
+		if bytes.HasPrefix(text, m.magic) {
+			return 1 - math.Pow(2, -8*float64(len(m.magic)))
+		}
+	}
+	return 0
+}
+
+// Detector names the kind of text a Scorer looks for
+type Detector struct {
+	Kind   string
+	Scorer Scorer
+}
+
+// AutoScorer runs every detector and keeps the most confident.
+// Detectors should score between 0 and 1, as the ones above do
+type AutoScorer struct {
+	Detectors []Detector
+}
+
+// NewAutoScorer returns a detector for each of the given language
+// models, the embedded english one when there are none, followed by
+// the structured data detectors
+func NewAutoScorer(models ...*CorpusModel) (*AutoScorer, error) {
+
+	if len(models) == 0 {
+		m, err := initCorpus()
+		if err != nil {
+			return nil, err
+		}
+		models = []*CorpusModel{m}
+	}
+
+	a := &AutoScorer{}
+	for _, m := range models {
+		a.Detectors = append(a.Detectors, Detector{m.Language, LanguageDetector(m)})
+	}
+	a.Detectors = append(a.Detectors,
+		Detector{"json", JSONScorer{}},
+		Detector{"hex", HexScorer{}},
+		Detector{"base64", Base64Scorer{}},
+		Detector{"utf-16", UTF16Scorer{}},
+		Detector{"magic", MagicScorer{}},
+	)
+
+	return a, nil
+}
+
+// Classify returns the kind of text the most confident detector looks
+// for, along with its score. Ties go to the first detector
+func (a *AutoScorer) Classify(text []byte) (string, float64) {
+
+	var kind string
+	best := math.Inf(-1)
+	for _, d := range a.Detectors {
+		if s := d.Scorer.Score(text); s > best {
+			kind, best = d.Kind, s
+		}
+	}
+
+	if kind == "magic" {
+		if name, ok := FileMagic(text); ok {
+			kind = name
+		}
+	}
+
+	return kind, best
+}
+
+// Score implements Scorer
+func (a *AutoScorer) Score(text []byte) float64 {
+	_, s := a.Classify(text)
+	return s
+}
+
 // LoadCorpus is a helper function that opens a text file
 // and returns its content
 func LoadCorpus(filename string) (string, error) {
@@ -126,49 +549,424 @@ func LoadCorpus(filename string) (string, error) {
 	return string(text), nil
 }
 
-// initCorpus is a helper function that returns Alice in Wonderland
-// and the frequency of english letters, obtained analyzing Alice in Wonderland
-func initCorpus() (string, map[rune]float64) {
+// CorpusModel holds everything the scorers learn from a corpus, so that
+// it can be trained once, saved and loaded back instead of analysing the
+// text at every use
+type CorpusModel struct {
+	Language string
+	// Runes are the frequencies returned by AnalyzeCorpus
+	Runes map[rune]float64
+	// Classes are the frequencies ChiSquaredScorer compares against
+	Classes [chiClasses]float64
+	// N is the length of the n-grams, counted in Grams along
+	// with their first n-1 bytes in Contexts
+	N        int
+	Grams    map[string]float64
+	Contexts map[string]float64
+}
+
+// TrainCorpusModel analyses corpus, counting n-grams of length n
+func TrainCorpusModel(language, corpus string, n int) (*CorpusModel, error) {
+
+	grams, err := NewNGramScorer(corpus, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CorpusModel{
+		Language: language,
+		Runes:    AnalyzeCorpus(corpus),
+		Classes:  NewChiSquaredScorer(corpus).freq,
+		N:        n,
+		Grams:    grams.grams,
+		Contexts: grams.contexts,
+	}, nil
+}
+
+// TrainCorpusModelFile is TrainCorpusModel on the content of a text file
+func TrainCorpusModelFile(language, filename string, n int) (*CorpusModel, error) {
 
-	data, err := LoadCorpus("_testdata/aliceinwonderland.txt")
+	corpus, err := LoadCorpus(filename)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	return data, AnalyzeCorpus(data)
+	return TrainCorpusModel(language, corpus, n)
+}
+
+// Save writes the model to w, gob encoded and gzipped
+func (m *CorpusModel) Save(w io.Writer) error {
+
+	z := gzip.NewWriter(w)
+	if err := gob.NewEncoder(z).Encode(m); err != nil {
+		return err
+	}
+
+	return z.Close()
+}
+
+// LoadCorpusModel reads a model written by Save
+func LoadCorpusModel(r io.Reader) (*CorpusModel, error) {
+
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	m := &CorpusModel{}
+	if err := gob.NewDecoder(z).Decode(m); err != nil {
+		return nil, err
+	}
+	if m.N < 2 || m.Grams == nil || m.Contexts == nil {
+		return nil, errors.New("incomplete corpus model")
+	}
+
+	return m, nil
+}
+
+// LoadCorpusModelFile reads a model saved to a file
+func LoadCorpusModelFile(filename string) (*CorpusModel, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return LoadCorpusModel(f)
+}
+
+// FreqScorer returns the weighted sum scorer
+func (m *CorpusModel) FreqScorer() FreqScorer {
+	return FreqScorer(m.Runes)
+}
+
+// ChiSquaredScorer returns the unigram chi-squared scorer
+func (m *CorpusModel) ChiSquaredScorer() *ChiSquaredScorer {
+	return &ChiSquaredScorer{freq: m.Classes}
+}
+
+// NGramScorer returns the n-gram scorer
+func (m *CorpusModel) NGramScorer() *NGramScorer {
+	return &NGramScorer{n: m.N, grams: m.Grams, contexts: m.Contexts}
+}
+
+// Scorer returns the combined model NewEnglishScorer builds,
+// using the n-grams of m
+func (m *CorpusModel) Scorer() *CombinedScorer {
+	return &CombinedScorer{
+		Scorers:      []Scorer{m.ChiSquaredScorer(), m.NGramScorer()},
+		Weights:      []float64{1, 1},
+		MinPrintable: 0.9,
+	}
+}
+
+// Models shipped along with the package, one per language, trained
+// by models/gen.go
+//
+//go:generate go run models/gen.go
+//go:embed models/*.model
+var embeddedModels embed.FS
+
+// LanguageModel loads the embedded model of the given language
+func LanguageModel(language string) (*CorpusModel, error) {
+
+	f, err := embeddedModels.Open("models/" + language + ".model")
+	if err != nil {
+		return nil, fmt.Errorf("no model for language %q", language)
+	}
+	defer f.Close()
+
+	return LoadCorpusModel(f)
+}
+
+// Languages lists the languages with an embedded model
+func Languages() []string {
+
+	entries, err := embeddedModels.ReadDir("models")
+	if err != nil {
+		return nil
+	}
+
+	var languages []string
+	for _, e := range entries {
+		languages = append(languages, strings.TrimSuffix(e.Name(), ".model"))
+	}
+	return languages
+}
+
+var (
+	englishOnce  sync.Once
+	englishModel *CorpusModel
+	englishErr   error
+)
+
+// initCorpus is a helper function that returns the embedded english
+// model, trained on Alice in Wonderland. It's loaded once and shared
+func initCorpus() (*CorpusModel, error) {
+
+	englishOnce.Do(func() {
+		englishModel, englishErr = LanguageModel("english")
+	})
+
+	return englishModel, englishErr
 }
 
 // challenge 4
-func DetectSingleByteXor(in string, freq map[rune]float64) (byte, string, float64) {
-	var key byte
-	var plain string
-	var best_score float64
+func DetectSingleByteXor(in string, scorer Scorer) (byte, string, float64) {
 
 	b, err := hex.DecodeString(in)
 	if err != nil {
 		// bytes may note exist UTF8 (common when dealing with encrypted text)
 		b = []byte(in)
-		// log.Fatal(err)
 	}
 
-	for i := 1; i < 256; i++ {
-		enc, err := SingleByteXor(b, byte(i))
+	best := RankSingleByteXor(b, scorer, 1)[0]
+	return best.Key, string(best.Plain), best.Score
+}
+
+// XorCandidate is a single byte key along with
+// the plain text it yields and its score
+type XorCandidate struct {
+	Key   byte
+	Plain []byte
+	Score float64
+}
+
+// RankSingleByteXor tries every key byte, 0x00 included, and returns the
+// n best candidates sorted by decreasing score, all of them when n is not
+// positive. Ties keep the lower key first
+func RankSingleByteXor(in []byte, scorer Scorer, n int) []XorCandidate {
+
+	candidates := make([]XorCandidate, 256)
+	for k := 0; k < 256; k++ {
+		plain, _ := SingleByteXor(in, byte(k))
+		candidates[k] = XorCandidate{byte(k), plain, scorer.Score(plain)}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	if n > 0 && n < len(candidates) {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+// RecordEncoding tells how the records ScanSingleByteXor reads are encoded
+type RecordEncoding int
+
+const (
+	RawRecords RecordEncoding = iota
+	HexRecords
+	Base64Records
+)
+
+// XorScanConfig configures ScanSingleByteXor
+type XorScanConfig struct {
+	Encoding RecordEncoding
+	// RecordSize splits the stream into records of that many (encoded)
+	// bytes, the last one possibly shorter. Zero splits on new lines.
+	// Hex and base64 streams may be wrapped, new lines are not counted
+	RecordSize int
+	// MaxLineSize bounds the length of a line, 1MB when zero
+	MaxLineSize int
+	// Scorer ranks the keys of each record, english confidence
+	// (LanguageDetector) when nil
+	Scorer Scorer
+	// Candidates is how many keys to keep per record, at least one
+	Candidates int
+	// MinScore, if not nil, drops the records whose best key scores
+	// less. Most scorers give negative scores, nil keeps every record
+	MinScore *float64
+}
+
+// XorHit is a record along with its best keys
+type XorHit struct {
+	// Record is the index of the record, Offset where it starts in
+	// the stream
+	Record     int
+	Offset     int64
+	Candidates []XorCandidate
+}
+
+// ScanSingleByteXor reads r one record at a time, breaks each of them as
+// single byte xor and sends a hit for every record, or every record
+// scoring at least MinScore, in the order they come. Records that don't decode are
+// skipped. Only a record at a time is held in memory, so the size of
+// the stream doesn't matter.
+//
+// The hits channel is closed once r is exhausted or ctx is done, the
+// error channel then gets what stopped the scan, nil for the end of the
+// stream
+func ScanSingleByteXor(ctx context.Context, r io.Reader, cfg XorScanConfig) (<-chan XorHit, <-chan error) {
+
+	hits := make(chan XorHit)
+	errc := make(chan error, 1)
+
+	scorer := cfg.Scorer
+	if scorer == nil {
+		m, err := initCorpus()
 		if err != nil {
-			log.Fatal(err)
+			close(hits)
+			errc <- err
+			return hits, errc
 		}
-		// log.Printf("%s", enc)
-		curr_score := ScoreEnglish(string(enc), freq)
-		if curr_score > best_score {
-			key = byte(i)
-			plain = string(enc)
-			best_score = curr_score
+		scorer = LanguageDetector(m)
+	}
+	candidates := cfg.Candidates
+	if candidates < 1 {
+		candidates = 1
+	}
+	maxLine := cfg.MaxLineSize
+	if maxLine <= 0 {
+		maxLine = 1 << 20
+	}
+	if cfg.RecordSize > maxLine {
+		maxLine = cfg.RecordSize
+	}
+
+	go func() {
+		defer close(hits)
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLine)
+
+		// Both split functions hand out the whole record, the new line
+		// aside: keep track of where each one starts
+		split := bufio.ScanLines
+		if cfg.RecordSize > 0 && cfg.Encoding == RawRecords {
+			split = func(data []byte, atEOF bool) (int, []byte, error) {
+				if len(data) >= cfg.RecordSize {
+					return cfg.RecordSize, data[:cfg.RecordSize], nil
+				}
+				if atEOF && len(data) > 0 {
+					return len(data), data, nil
+				}
+				return 0, nil, nil
+			}
+		} else if cfg.RecordSize > 0 {
+			split = func(data []byte, atEOF bool) (int, []byte, error) {
+				// New lines before a record are skipped on their own,
+				// so that the record starts where its offset says
+				skip := 0
+				for skip < len(data) && (data[skip] == '\n' || data[skip] == '\r') {
+					skip++
+				}
+				if skip > 0 {
+					return skip, nil, nil
+				}
+
+				var token []byte
+				i := 0
+				for ; i < len(data) && len(token) < cfg.RecordSize; i++ {
+					if data[i] != '\n' && data[i] != '\r' {
+						token = append(token, data[i])
+					}
+				}
+				if len(token) == cfg.RecordSize || (atEOF && len(token) > 0) {
+					return i, token, nil
+				}
+				return 0, nil, nil
+			}
 		}
+		var start, next int64
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			advance, token, err := split(data, atEOF)
+			if token != nil {
+				start = next
+			}
+			next += int64(advance)
+			return advance, token, err
+		})
+
+		for record := 0; scanner.Scan(); record++ {
+			if err := ctx.Err(); err != nil {
+				errc <- err
+				return
+			}
+
+			var in []byte
+			var err error
+			switch cfg.Encoding {
+			case HexRecords:
+				in, err = hex.DecodeString(string(scanner.Bytes()))
+			case Base64Records:
+				in, err = base64.StdEncoding.DecodeString(string(scanner.Bytes()))
+			default:
+				in = append([]byte{}, scanner.Bytes()...)
+			}
+			if err != nil || len(in) == 0 {
+				continue
+			}
+
+			ranked := RankSingleByteXor(in, scorer, candidates)
+			if cfg.MinScore != nil && ranked[0].Score < *cfg.MinScore {
+				continue
+			}
+
+			select {
+			case hits <- XorHit{record, start, ranked}:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
+			}
+		}
+
+		errc <- scanner.Err()
+	}()
+
+	return hits, errc
+}
+
+// TopXorHits drains hits and returns the n best of them, by the score
+// of their best key, in decreasing order. Memory stays bounded by n
+func TopXorHits(hits <-chan XorHit, n int) []XorHit {
+
+	h := &xorHitHeap{}
+	for hit := range hits {
+		if n < 1 {
+			continue
+		}
+		if h.Len() < n {
+			heap.Push(h, hit)
+		} else if hit.Candidates[0].Score > (*h)[0].Candidates[0].Score {
+			(*h)[0] = hit
+			heap.Fix(h, 0)
+		}
+	}
+
+	top := make([]XorHit, h.Len())
+	for i := len(top) - 1; i >= 0; i-- {
+		top[i] = heap.Pop(h).(XorHit)
 	}
-	return key, plain, best_score
+	return top
+}
+
+// xorHitHeap is a min heap of hits, worst on top
+type xorHitHeap []XorHit
+
+func (h xorHitHeap) Len() int { return len(h) }
+func (h xorHitHeap) Less(i, j int) bool {
+	return h[i].Candidates[0].Score < h[j].Candidates[0].Score
+}
+func (h xorHitHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *xorHitHeap) Push(x interface{}) { *h = append(*h, x.(XorHit)) }
+func (h *xorHitHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
 }
 
 // challenge 5
-func RepeatingKeyXor(in, key []byte) []byte {
+func RepeatingKeyXor(in, key []byte) ([]byte, error) {
+
+	if len(key) == 0 {
+		return nil, fmt.Errorf("%w, the key is empty", ErrKeySize)
+	}
 
 	var r []byte = make([]byte, len(in))
 	l := len(key)
@@ -177,17 +975,17 @@ func RepeatingKeyXor(in, key []byte) []byte {
 		r[i] = in[i] ^ byte(key[i%l])
 	}
 
-	return r
+	return r, nil
 }
 
 // challenge 6
-func ComputeHammingDistance(s1, s2 []byte) int {
+func ComputeHammingDistance(s1, s2 []byte) (int, error) {
 	var distance int
 	mask := byte(01)
 
 	xor, err := Xor(s1, s2)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	for i := 0; i < len(xor); i++ {
@@ -199,13 +997,18 @@ func ComputeHammingDistance(s1, s2 []byte) int {
 			b = b >> 1
 		}
 	}
-	return distance
+	return distance, nil
 }
 
-// Split splits a buffer @buf in N blocks of @size size
+// Split splits a buffer @buf in N blocks of @size size,
+// nothing for a size less than 1
 func Split(buf []byte, size int) [][]byte {
 	var chunk []byte
 
+	if size < 1 {
+		return nil
+	}
+
 	chunks := make([][]byte, 0, len(buf)/size+1)
 	for len(buf) >= size {
 		chunk, buf = buf[:size], buf[size:]
@@ -217,15 +1020,25 @@ func Split(buf []byte, size int) [][]byte {
 	return chunks
 }
 
-// TransposeBlocks will transpose a N x M matrix
+// TransposeBlocks will transpose a N x M matrix. Rows may be shorter
+// than others, as the last block Split returns
 func TransposeBlocks(in [][]byte) [][]byte {
 
+	cols := 0
+	for _, row := range in {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	if cols == 0 {
+		return nil
+	}
+
 	// Create a matrix with N=col and M=1
-	transposed_blocks := make([][]byte, len(in[0]))
+	transposed_blocks := make([][]byte, cols)
 
 	for i := 0; i < len(in); i++ {
 		for j := 0; j < len(in[i]); j++ {
-			// log.Printf("i: %d, j: %d", i, j)
 			transposed_blocks[j] = append(transposed_blocks[j], in[i][j])
 		}
 	}
@@ -234,29 +1047,29 @@ func TransposeBlocks(in [][]byte) [][]byte {
 
 // BreakRepeatingKeyXor recovers the plain text and the key
 // from an encrypted byte array @in
-func BreakRepeatingKeyXor(in []byte) ([]byte, string) {
+func BreakRepeatingKeyXor(in []byte) ([]byte, string, error) {
 	var key []byte
 	var keys []int
 	var partial_key []byte
 	var plain string
 	var plaintext string
 	var score float64
-	var best_score float64
+	best_score := math.Inf(-1)
 
-	// Storing all keys with their respective distances may be interesting...
-	distances := make(map[int]float64, 41)
-
-	for i := 2; i < len(in) && i < 41; i++ {
-		s := float64(ComputeHammingDistance(in[:i], in[i:i*2]))
-		// t := float64(ComputeHammingDistance(in[:i*3], in[i:i*4]))
-		// d := (s + t) / (2 * float64(i))
-		d := s / float64(i)
-		distances[i] = d
+	scorer, err := columnScorer()
+	if err != nil {
+		return nil, "", err
 	}
 
-	// Extract keysizes and sort them in decreasing order
-	for k, _ := range distances {
-		keys = append(keys, k)
+	// Only the most likely keysizes are worth breaking
+	for _, c := range EstimateKeysizes(in, 2, 40) {
+		if len(keys) == keysizeCandidates {
+			break
+		}
+		keys = append(keys, c.Size)
+	}
+	if len(keys) == 0 {
+		return nil, "", errors.New("no keysize to try")
 	}
 
 	// Make the cypher text go through every key
@@ -276,9 +1089,6 @@ func BreakRepeatingKeyXor(in []byte) ([]byte, string) {
 		tblocks := TransposeBlocks(blocks)
 		// tblocks := blocks
 
-		// Compute english's letters frequency
-		_, freq := initCorpus()
-
 		// Transposing blocks is only useful for the purpose of solving
 		// them with SingleByteXor() but not as much anything else.
 		// The plain text is not that interesting either as we would have
@@ -288,36 +1098,478 @@ func BreakRepeatingKeyXor(in []byte) ([]byte, string) {
 
 		// Solve each block as if it was single-byte XOR
 		for i := 0; i < len(tblocks); i++ {
-			k, p, s := DetectSingleByteXor(string(tblocks[i]), freq)
+			best := RankSingleByteXor(tblocks[i], scorer, 1)[0]
 
-			partial_key = append(partial_key, k)
-			plain += p
-			score += s
+			partial_key = append(partial_key, best.Key)
+			plain += string(best.Plain)
+			score += best.Score
 		}
 
 		// Since multiple keys are being tested, the one that scores the best
-		// is the one used for the encryption
-		if best_score < score {
+		// is the one used for the encryption. Scores are per byte, so
+		// they are averaged over the columns
+		score /= float64(len(tblocks))
+		if key == nil || best_score < score {
 			key = partial_key
 			plaintext = plain
 			best_score = score
 		}
 	}
 
-	plaintext = string(RepeatingKeyXor(in, key))
-	return key, plaintext
+	// A multiple of the keysize breaks the text just as well,
+	// with the key repeated
+	key = keyPeriod(key)
+
+	p, err := RepeatingKeyXor(in, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	plaintext = string(p)
+	return key, plaintext, nil
 }
 
-func AESDecryptECB(data, key []byte) ([]byte, error) {
+// keysizeCandidates is how many keysizes, the most likely
+// ones, the repeating key xor breakers try
+const keysizeCandidates = 5
 
-	plaintext := make([]byte, len(data))
+// keyPeriod returns the shortest key that repeats into key
+func keyPeriod(key []byte) []byte {
+	for p := 1; p < len(key); p++ {
+		if len(key)%p == 0 && bytes.Equal(key[p:], key[:len(key)-p]) {
+			return key[:p]
+		}
+	}
+	return key
+}
+
+// KeysizeCandidate is a guess at the size of a repeating xor key
+type KeysizeCandidate struct {
+	Size       int
+	Confidence float64
+}
+
+// HammingKeysizes averages the normalised Hamming distance over every
+// pair of consecutive blocks, rather than only the first two: with the
+// right keysize, blocks are xored with the same key and their distance
+// is the one of the plain texts, lower than the one of random bytes.
+// Scores are negated distances, so that higher is better
+func HammingKeysizes(in []byte, min, max int) map[int]float64 {
+
+	scores := make(map[int]float64)
+	for k := min; k <= max; k++ {
+		blocks := len(in) / k
+		if k < 1 || blocks < 2 {
+			continue
+		}
+
+		var d float64
+		for i := 0; i+1 < blocks; i++ {
+			// Blocks have the same length, there is no error
+			h, _ := ComputeHammingDistance(in[i*k:(i+1)*k], in[(i+1)*k:(i+2)*k])
+			d += float64(h)
+		}
+		scores[k] = -d / float64(k) / float64(blocks-1)
+	}
+
+	return scores
+}
+
+// CoincidenceKeysizes averages the index of coincidence of the columns:
+// the chance that two bytes picked from the same column are equal. Xored
+// with the same byte, a column keeps the one of its plain text, about
+// 0.06 for english against 1/256 for random bytes
+func CoincidenceKeysizes(in []byte, min, max int) map[int]float64 {
+
+	scores := make(map[int]float64)
+	for k := min; k <= max; k++ {
+		if k < 1 || len(in)/k < 2 {
+			continue
+		}
+
+		var ic float64
+		for _, column := range TransposeBlocks(Split(in, k)) {
+			var counts [256]float64
+			for _, b := range column {
+				counts[b]++
+			}
+			n := float64(len(column))
+			var c float64
+			for _, f := range counts {
+				c += f * (f - 1)
+			}
+			if n > 1 {
+				ic += c / (n * (n - 1))
+			}
+		}
+		scores[k] = ic / float64(k)
+	}
+
+	return scores
+}
+
+// AutocorrelationKeysizes is Kasiski's method taken down to single bytes:
+// shifting the text by a multiple of the keysize lines up bytes xored
+// with the same key byte, which match as often as the plain text does.
+// The score is the average match rate over the first three multiples
+func AutocorrelationKeysizes(in []byte, min, max int) map[int]float64 {
+
+	scores := make(map[int]float64)
+	for k := min; k <= max; k++ {
+		if k < 1 || len(in)/k < 2 {
+			continue
+		}
+
+		var rate float64
+		shifts := 0
+		for shift := k; shift <= 3*k && shift < len(in); shift += k {
+			matches := 0
+			for i := 0; i+shift < len(in); i++ {
+				if in[i] == in[i+shift] {
+					matches++
+				}
+			}
+			rate += float64(matches) / float64(len(in)-shift)
+			shifts++
+		}
+		scores[k] = rate / float64(shifts)
+	}
+
+	return scores
+}
+
+// EstimateKeysizes ranks the keysizes from min to max combining the three
+// estimators above: each score is turned into a z-score over the sizes,
+// the three are averaged and a softmax turns the result into confidences
+// adding up to 1. Sizes come sorted by decreasing confidence, the smaller
+// first on ties
+func EstimateKeysizes(in []byte, min, max int) []KeysizeCandidate {
+
+	if min < 1 {
+		min = 1
+	}
+
+	ic := CoincidenceKeysizes(in, min, max)
+	methods := []map[int]float64{
+		HammingKeysizes(in, min, max),
+		ic,
+		AutocorrelationKeysizes(in, min, max),
+	}
+
+	combined := make(map[int]float64)
+	for _, scores := range methods {
+		var mean, sd float64
+		for _, v := range scores {
+			mean += v
+		}
+		mean /= float64(len(scores))
+		for _, v := range scores {
+			sd += (v - mean) * (v - mean)
+		}
+		sd = math.Sqrt(sd / float64(len(scores)))
+
+		// A flat estimator adds nothing, the size still gets ranked
+		for k, v := range scores {
+			var z float64
+			if sd > 0 {
+				z = (v - mean) / sd
+			}
+			combined[k] += z / float64(len(methods))
+		}
+	}
+
+	// Every multiple of the keysize scores about as well, only with
+	// shorter columns: when a divisor explains the text just as well,
+	// the multiple ranks right below it
+	for k := min; k <= max; k++ {
+		for d := min; d < k; d++ {
+			if _, ok := combined[d]; !ok || k%d != 0 || ic[d] < 0.75*ic[k] {
+				continue
+			}
+			if combined[k] >= combined[d] {
+				combined[k] = combined[d] - 1e-3
+			}
+		}
+	}
+
+	var candidates []KeysizeCandidate
+	var total float64
+	for k := min; k <= max; k++ {
+		z, ok := combined[k]
+		if !ok {
+			continue
+		}
+		c := math.Exp(z)
+		candidates = append(candidates, KeysizeCandidate{k, c})
+		total += c
+	}
+	for i := range candidates {
+		candidates[i].Confidence /= total
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Confidence > candidates[j].Confidence
+	})
+
+	return candidates
+}
+
+// columnScorer scores the columns of a repeating key xor. Columns are
+// not contiguous text, only the byte distribution tells english apart
+func columnScorer() (Scorer, error) {
+	m, err := initCorpus()
+	if err != nil {
+		return nil, err
+	}
+	return &CombinedScorer{
+		Scorers:      []Scorer{m.ChiSquaredScorer()},
+		Weights:      []float64{1},
+		MinPrintable: 0.9,
+	}, nil
+}
+
+// RepeatingKeyCandidate is a repeating xor key along with the plain
+// text it yields and its score, the average of its column scores
+type RepeatingKeyCandidate struct {
+	Key   []byte
+	Plain []byte
+	Score float64
+}
+
+// BeamSearchKey combines the ranked candidates of each column into
+// whole keys, column after column, keeping the beam best partial keys
+// at every step. It returns the n best keys, all of those left when n
+// is not positive. Plain is not filled in, the columns don't know
+// about the cipher text
+func BeamSearchKey(columns [][]XorCandidate, beam, n int) []RepeatingKeyCandidate {
+
+	if beam < 1 {
+		beam = 1
+	}
+
+	type partial struct {
+		key []byte
+		sum float64
+	}
+	keys := []partial{{}}
+
+	for _, column := range columns {
+		if len(column) > beam {
+			column = column[:beam]
+		}
+
+		next := make([]partial, 0, len(keys)*len(column))
+		for _, k := range keys {
+			for _, c := range column {
+				key := make([]byte, len(k.key)+1)
+				copy(key, k.key)
+				key[len(k.key)] = c.Key
+				next = append(next, partial{key, k.sum + c.Score})
+			}
+		}
+
+		sort.SliceStable(next, func(i, j int) bool {
+			return next[i].sum > next[j].sum
+		})
+		if len(next) > beam {
+			next = next[:beam]
+		}
+		keys = next
+	}
+
+	if n > 0 && n < len(keys) {
+		keys = keys[:n]
+	}
+
+	candidates := make([]RepeatingKeyCandidate, len(keys))
+	for i, k := range keys {
+		candidates[i] = RepeatingKeyCandidate{Key: k.key, Score: k.sum / float64(len(columns))}
+	}
+	return candidates
+}
+
+// RankRepeatingKeyXor breaks in for every keysize, ranking beam bytes per
+// column and combining them with BeamSearchKey. When keysizes is empty
+// the most likely sizes between 2 and 40 are picked by EstimateKeysizes.
+// It returns the n best keys over all the keysizes, cut to their shortest
+// period and without duplicates, sorted by decreasing score, or all of
+// them when n is not positive. A nil scorer scores the columns as english
+func RankRepeatingKeyXor(in []byte, scorer Scorer, keysizes []int, beam, n int) ([]RepeatingKeyCandidate, error) {
+
+	scorer, keysizes, err := repeatingKeyDefaults(in, scorer, keysizes)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([][][]XorCandidate, len(keysizes))
+	for k, keysize := range keysizes {
+		tblocks := TransposeBlocks(Split(in, keysize))
+
+		columns[k] = make([][]XorCandidate, len(tblocks))
+		for i := range tblocks {
+			columns[k][i] = RankSingleByteXor(tblocks[i], scorer, beam)
+		}
+	}
+
+	return combineKeysizes(in, columns, beam, n)
+}
+
+// RankRepeatingKeyXorContext is RankRepeatingKeyXor with the columns of
+// every keysize ranked by a pool of workers goroutines, one per CPU when
+// workers is not positive. The scorer must be safe for concurrent use,
+// the ones in this package are. The results are the same as the
+// sequential ones, unless ctx is done first: then its error is returned
+func RankRepeatingKeyXorContext(ctx context.Context, in []byte, scorer Scorer, keysizes []int, beam, n, workers int) ([]RepeatingKeyCandidate, error) {
+
+	scorer, keysizes, err := repeatingKeyDefaults(in, scorer, keysizes)
+	if err != nil {
+		return nil, err
+	}
+	if workers < 1 {
+		workers = runtime.NumCPU()
+	}
+
+	type job struct {
+		k, i int
+	}
+
+	tblocks := make([][][]byte, len(keysizes))
+	columns := make([][][]XorCandidate, len(keysizes))
+	for k, keysize := range keysizes {
+		tblocks[k] = TransposeBlocks(Split(in, keysize))
+		columns[k] = make([][]XorCandidate, len(tblocks[k]))
+	}
+
+	// Every worker writes its own slots, no need to lock
+	var wg sync.WaitGroup
+	jobs := make(chan job)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				columns[j.k][j.i] = RankSingleByteXor(tblocks[j.k][j.i], scorer, beam)
+			}
+		}()
+	}
+
+	err = ctx.Err()
+feed:
+	for k := 0; k < len(tblocks) && err == nil; k++ {
+		for i := range tblocks[k] {
+			select {
+			case jobs <- job{k, i}:
+			case <-ctx.Done():
+				err = ctx.Err()
+				break feed
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return combineKeysizes(in, columns, beam, n)
+}
+
+// BreakRepeatingKeyXorContext is BreakRepeatingKeyXor spread over
+// workers goroutines, see RankRepeatingKeyXorContext
+func BreakRepeatingKeyXorContext(ctx context.Context, in []byte, workers int) ([]byte, string, error) {
+
+	candidates, err := RankRepeatingKeyXorContext(ctx, in, nil, nil, 1, 1, workers)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(candidates) == 0 {
+		return nil, "", errors.New("no keysize to try")
+	}
+
+	return candidates[0].Key, string(candidates[0].Plain), nil
+}
+
+// repeatingKeyDefaults fills in the scorer and the keysizes
+// the rankers use when given none, and drops the sizes in is
+// too short for
+func repeatingKeyDefaults(in []byte, scorer Scorer, keysizes []int) (Scorer, []int, error) {
+
+	if scorer == nil {
+		var err error
+		scorer, err = columnScorer()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(keysizes) == 0 {
+		for _, c := range EstimateKeysizes(in, 2, 40) {
+			if len(keysizes) == keysizeCandidates {
+				break
+			}
+			keysizes = append(keysizes, c.Size)
+		}
+	}
+
+	var valid []int
+	for _, keysize := range keysizes {
+		if keysize >= 1 && keysize <= len(in) {
+			valid = append(valid, keysize)
+		}
+	}
+
+	return scorer, valid, nil
+}
+
+// combineKeysizes runs BeamSearchKey on the ranked columns of each
+// keysize and merges the results, as RankRepeatingKeyXor describes
+func combineKeysizes(in []byte, columns [][][]XorCandidate, beam, n int) ([]RepeatingKeyCandidate, error) {
+
+	var candidates []RepeatingKeyCandidate
+	seen := make(map[string]bool)
+	for _, cols := range columns {
+		for _, c := range BeamSearchKey(cols, beam, beam) {
+			c.Key = keyPeriod(c.Key)
+			if seen[string(c.Key)] {
+				continue
+			}
+			seen[string(c.Key)] = true
+			plain, err := RepeatingKeyXor(in, c.Key)
+			if err != nil {
+				return nil, err
+			}
+			c.Plain = plain
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	if n > 0 && n < len(candidates) {
+		candidates = candidates[:n]
+	}
+	return candidates, nil
+}
+
+// newAESCipher is aes.NewCipher returning ErrKeySize for bad keys,
+// as the other ciphers do
+func newAESCipher(key []byte) (cipher.Block, error) {
 	keySize := len(key)
 
-	if keySize != 8 && keySize != 16 && keySize != 32 {
-		return nil, errors.New(fmt.Sprintf("invalid key size, use 8, 16 or 32 bytes (64, 128, 256 bits)"))
+	if keySize != 16 && keySize != 24 && keySize != 32 {
+		return nil, fmt.Errorf("%w, use 16, 24 or 32 bytes (128, 192, 256 bits)", ErrKeySize)
 	}
 
-	blocks, err := aes.NewCipher(key)
+	return aes.NewCipher(key)
+}
+
+func AESDecryptECB(data, key []byte) ([]byte, error) {
+
+	plaintext := make([]byte, len(data))
+
+	blocks, err := newAESCipher(key)
 	if err != nil {
 		return nil, err
 	}
@@ -330,27 +1582,146 @@ func AESDecryptECB(data, key []byte) ([]byte, error) {
 }
 
 // challenge 8
-func DetectAESECB(in string) int {
+// DetectAESECB decodes a hex encoded cipher text and returns how many
+// times its most common 16 byte block shows up, more than once for ECB
+func DetectAESECB(in string) (int, error) {
+	data, err := hex.DecodeString(in)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidHex, err)
+	}
 
-	var freq map[string]int = make(map[string]int)
-	var max int
+	report, err := DetectECB(data, 16)
+	if err != nil {
+		return 0, err
+	}
+	return report.MaxRepeat, nil
+}
+
+// ECBReport describes the identical blocks of a cipher text
+type ECBReport struct {
+	BlockSize int
+	// Blocks counts the whole blocks, a trailing partial one is ignored
+	Blocks int
+	// Repeats tells how many times each block shows up
+	Repeats map[string]int
+	// Duplicates has the offsets of every block showing up more than
+	// once, grouped by block, in order of first appearance
+	Duplicates [][]int
+	// RepeatedBlocks counts the blocks equal to an earlier one,
+	// MaxRepeat how many times the most common block shows up
+	RepeatedBlocks int
+	MaxRepeat      int
+	// Probability is one minus the chance that blocks looking random,
+	// as other modes make them, collide as often
+	Probability float64
+}
 
-	size := 16
-	enc := []byte(in)
+// DetectECB looks for identical blocks of blockSize bytes in data. ECB
+// encrypts identical plain text blocks the same way, other modes only
+// collide by chance: about n^2/2 pairs out of 2^(8*blockSize) for n
+// blocks, which is what Probability weighs the repetitions against
+func DetectECB(data []byte, blockSize int) (*ECBReport, error) {
 
-	// Count frequencies for each block
-	for i := 0; i < len(enc)-size; i += size {
-		freq[in[i:i+size]] += 1
+	if blockSize < 1 {
+		return nil, errors.New("block size must be positive")
 	}
 
-	// Find the highest number of repeated blocks
-	// The higher the number of occurences, the
-	// higher the chance it was ECB encrypted
-	for _, v := range freq {
-		if v > max {
-			max = v
+	r := &ECBReport{
+		BlockSize: blockSize,
+		Blocks:    len(data) / blockSize,
+		Repeats:   make(map[string]int),
+	}
+
+	offsets := make(map[string][]int)
+	var order []string
+	for i := 0; i+blockSize <= len(data); i += blockSize {
+		b := string(data[i : i+blockSize])
+		if r.Repeats[b] == 0 {
+			order = append(order, b)
+		} else {
+			r.RepeatedBlocks++
+		}
+		r.Repeats[b]++
+		offsets[b] = append(offsets[b], i)
+		if r.Repeats[b] > r.MaxRepeat {
+			r.MaxRepeat = r.Repeats[b]
+		}
+	}
+
+	for _, b := range order {
+		if len(offsets[b]) > 1 {
+			r.Duplicates = append(r.Duplicates, offsets[b])
+		}
+	}
+
+	if r.RepeatedBlocks > 0 {
+		// Among random blocks the number of colliding pairs follows a
+		// Poisson law of mean n(n-1)/2 / 2^(8*blockSize): the chance of
+		// seeing at least as many is the chance of a false positive
+		pairs := 0
+		for _, c := range r.Repeats {
+			pairs += c * (c - 1) / 2
+		}
+		n := float64(r.Blocks)
+		lambda := n * (n - 1) / 2 * math.Pow(2, -8*float64(blockSize))
+
+		below, term := 0.0, math.Exp(-lambda)
+		for k := 0; k < pairs; k++ {
+			below += term
+			term *= lambda / float64(k+1)
+		}
+		r.Probability = math.Min(1, below)
+	}
+
+	return r, nil
+}
+
+// ECBLine is the report of a line of ScanECB
+type ECBLine struct {
+	Line   int
+	Report *ECBReport
+}
+
+// ScanECB runs DetectECB on each line of r, decoded as enc says, and
+// returns the lines with repeated blocks sorted by decreasing number of
+// repetitions, the earlier line first on ties. Lines that don't decode
+// are skipped
+func ScanECB(r io.Reader, blockSize int, enc RecordEncoding) ([]ECBLine, error) {
+
+	var lines []ECBLine
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for i := 0; scanner.Scan(); i++ {
+		var data []byte
+		var err error
+		switch enc {
+		case HexRecords:
+			data, err = hex.DecodeString(scanner.Text())
+		case Base64Records:
+			data, err = base64.StdEncoding.DecodeString(scanner.Text())
+		default:
+			data = scanner.Bytes()
+		}
+		if err != nil {
+			continue
+		}
+
+		report, err := DetectECB(data, blockSize)
+		if err != nil {
+			return nil, err
+		}
+		if report.RepeatedBlocks > 0 {
+			lines = append(lines, ECBLine{i, report})
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Report.RepeatedBlocks > lines[j].Report.RepeatedBlocks
+	})
 
-	return max
+	return lines, nil
 }
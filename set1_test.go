@@ -3,11 +3,14 @@ package matasano
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
 	"os"
 	"reflect"
 	"testing"
@@ -17,7 +20,17 @@ func TestProblem1(t *testing.T) {
 	test := "49276d206b696c6c696e6720796f757220627261696e206c696b65206120706f69736f6e6f7573206d757368726f6f6d"
 	expected := "SSdtIGtpbGxpbmcgeW91ciBicmFpbiBsaWtlIGEgcG9pc29ub3VzIG11c2hyb29t"
 
-	if r := HexToBase64(test); expected != r {
+	r, err := HexToBase64(test)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if expected != r {
+		t.FailNow()
+	}
+
+	if _, err := HexToBase64("4927zz"); !errors.Is(err, ErrInvalidHex) {
+		t.Logf("got: %v, want: %v", err, ErrInvalidHex)
 		t.FailNow()
 	}
 }
@@ -101,10 +114,17 @@ func TestProblem4(t *testing.T) {
 	}
 	defer file.Close()
 
-	_, freq := initCorpus()
+	english, err := initCorpus()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	scorer := english.Scorer()
+
+	best_score = math.Inf(-1)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		if k, p, score := DetectSingleByteXor(scanner.Text(), freq); score > best_score {
+		if k, p, score := DetectSingleByteXor(scanner.Text(), scorer); score > best_score {
 			key = k
 			plain = p
 			best_score = score
@@ -117,12 +137,486 @@ func TestProblem4(t *testing.T) {
 	t.Logf("%s\tkey:\t%v", plain, key)
 }
 
+func TestScorers(t *testing.T) {
+	corpus, err := LoadCorpus("_testdata/aliceinwonderland.txt")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	bigrams, err := NewNGramScorer(corpus, 2)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	trigrams, err := NewNGramScorer(corpus, 3)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	english, err := NewEnglishScorer(corpus)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	scorers := map[string]Scorer{
+		"frequency":   FreqScorer(AnalyzeCorpus(corpus)),
+		"chi-squared": NewChiSquaredScorer(corpus),
+		"bigram":      bigrams,
+		"trigram":     trigrams,
+		"printable":   PrintableScorer{},
+		"combined":    english,
+	}
+
+	plain := []byte("Cooking MC's like a pound of bacon")
+	garbage, err := RepeatingKeyXor(plain, []byte{0xa5})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	for name, s := range scorers {
+		if p, g := s.Score(plain), s.Score(garbage); p <= g {
+			t.Logf("%s: plain text scores %v, garbage %v", name, p, g)
+			t.Fail()
+		}
+	}
+
+	// A text full of the most common letter fools the weighted sum,
+	// not the models that look at the whole distribution
+	eees := []byte("eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee")
+	for _, name := range []string{"chi-squared", "trigram", "combined"} {
+		if p, e := scorers[name].Score(plain), scorers[name].Score(eees); p <= e {
+			t.Logf("%s: plain text scores %v, repeated letter %v", name, p, e)
+			t.Fail()
+		}
+	}
+}
+
+func TestCorpusModel(t *testing.T) {
+	m, err := TrainCorpusModelFile("english", "_testdata/aliceinwonderland.txt", 3)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	var buf bytes.Buffer
+	if err := m.Save(&buf); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	loaded, err := LoadCorpusModel(&buf)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// The embedded model was trained on the same text
+	embedded, err := LanguageModel("english")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	text := []byte("Cooking MC's like a pound of bacon")
+	want := m.Scorer().Score(text)
+	for _, got := range []float64{loaded.Scorer().Score(text), embedded.Scorer().Score(text)} {
+		if got != want {
+			t.Logf("got: %v, want: %v", got, want)
+			t.FailNow()
+		}
+	}
+
+	if langs := Languages(); !reflect.DeepEqual(langs, []string{"english"}) {
+		t.Logf("got: %v, want: [english]", langs)
+		t.FailNow()
+	}
+	if _, err := LanguageModel("klingon"); err == nil {
+		t.Log("loaded a model that does not exist")
+		t.FailNow()
+	}
+	if _, err := LoadCorpusModel(bytes.NewReader([]byte("not a model"))); err == nil {
+		t.Log("loaded garbage as a model")
+		t.FailNow()
+	}
+}
+
+func TestDetectors(t *testing.T) {
+	italian, err := TrainCorpusModel("italian", "Nel mezzo del cammin di nostra vita mi ritrovai per una selva oscura, "+
+		"che la diritta via era smarrita. Ahi quanto a dir qual era e cosa dura esta selva selvaggia e aspra e forte "+
+		"che nel pensier rinova la paura! Tant e amara che poco e piu morte; ma per trattar del ben ch'i' vi trovai, "+
+		"diro de l'altre cose ch'i' v'ho scorte. Io non so ben ridir com' i' v'intrai, tant' era pien di sonno a quel "+
+		"punto che la verace via abbandonai.", 3)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	english, err := initCorpus()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	auto, err := NewAutoScorer(english, italian)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), 0, 0, 0, 13, 'I', 'H', 'D', 'R', 0, 0, 1, 0)
+	utf16 := []byte{}
+	for _, c := range "Hello, World" {
+		utf16 = append(utf16, byte(c), 0)
+	}
+
+	tests := []struct {
+		kind  string
+		plain []byte
+	}{
+		{"english", []byte("It was the best of times, it was the worst of times")},
+		{"italian", []byte("amor, ch'a nullo amato amar perdona, mi prese del costui piacer si forte")},
+		{"json", []byte(`{"user":"admin","id":42,"roles":["a","b"]}`)},
+		{"hex", []byte("49276d206b696c6c696e6720796f757220627261696e")},
+		{"base64", []byte("SSdtIGtpbGxpbmcgeW91ciBicmFpbiBsaWtlIGEgcG9pc29ub3Vz")},
+		{"utf-16", utf16},
+		{"png", png},
+	}
+
+	for _, tt := range tests {
+		if kind, _ := auto.Classify(tt.plain); kind != tt.kind {
+			t.Logf("got: %s, want: %s", kind, tt.kind)
+			t.Fail()
+			continue
+		}
+
+		enc, err := RepeatingKeyXor(tt.plain, []byte{0x5a})
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if key, _, _ := DetectSingleByteXor(string(enc), auto); key != 0x5a {
+			t.Logf("%s: got key: %#x, want: 0x5a", tt.kind, key)
+			t.Fail()
+		}
+	}
+}
+
+func TestRankSingleByteXor(t *testing.T) {
+	plain := []byte("Cooking MC's like a pound of bacon")
+	english, err := initCorpus()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	scorer := english.Scorer()
+
+	// Key 0x00 is a key like any other
+	all := RankSingleByteXor(plain, scorer, 0)
+	if len(all) != 256 {
+		t.Logf("got: %d candidates, want: 256", len(all))
+		t.FailNow()
+	}
+	if all[0].Key != 0 || !bytes.Equal(all[0].Plain, plain) {
+		t.Logf("got: %#x, want: 0x00", all[0].Key)
+		t.FailNow()
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Score > all[i-1].Score {
+			t.Logf("candidate %d scores more than %d", i, i-1)
+			t.FailNow()
+		}
+	}
+
+	enc, err := RepeatingKeyXor(plain, []byte("X"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if top := RankSingleByteXor(enc, scorer, 3); len(top) != 3 || top[0].Key != 'X' {
+		t.Logf("got: %+v, want 3 candidates starting with 'X'", top)
+		t.FailNow()
+	}
+}
+
+func TestBeamSearchKey(t *testing.T) {
+	columns := [][]XorCandidate{
+		{{Key: 'a', Score: -1}, {Key: 'b', Score: -2}},
+		{{Key: 'c', Score: -1}, {Key: 'd', Score: -1.5}},
+	}
+
+	got := BeamSearchKey(columns, 2, 0)
+	want := []string{"ac", "ad"}
+	if len(got) != len(want) {
+		t.Logf("got: %d keys, want: %d", len(got), len(want))
+		t.FailNow()
+	}
+	for i := range want {
+		if string(got[i].Key) != want[i] {
+			t.Logf("got: %s, want: %s", got[i].Key, want[i])
+			t.FailNow()
+		}
+	}
+	if got[1].Score != -1.25 {
+		t.Logf("got: %v, want: -1.25", got[1].Score)
+		t.FailNow()
+	}
+}
+
+func TestRankRepeatingKeyXor(t *testing.T) {
+	b64, err := ioutil.ReadFile("_testdata/6.txt")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	enc, err := base64.StdEncoding.DecodeString(string(b64))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	candidates, err := RankRepeatingKeyXor(enc, nil, nil, 3, 5)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if len(candidates) != 5 {
+		t.Logf("got: %d candidates, want: 5", len(candidates))
+		t.FailNow()
+	}
+	if string(candidates[0].Key) != "Terminator X: Bring the noise" {
+		t.Logf("got: %q, want: %q", candidates[0].Key, "Terminator X: Bring the noise")
+		t.FailNow()
+	}
+	for _, c := range candidates {
+		t.Logf("%.4f %q", c.Score, c.Key)
+	}
+}
+
+func TestRankRepeatingKeyXorContext(t *testing.T) {
+	b64, err := ioutil.ReadFile("_testdata/6.txt")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	enc, err := base64.StdEncoding.DecodeString(string(b64))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	want, err := RankRepeatingKeyXor(enc, nil, nil, 3, 0)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	got, err := RankRepeatingKeyXorContext(context.Background(), enc, nil, nil, 3, 0, 4)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Log("concurrent ranking differs from the sequential one")
+		t.FailNow()
+	}
+
+	wantKey, wantPlain, err := BreakRepeatingKeyXor(enc)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	key, plain, err := BreakRepeatingKeyXorContext(context.Background(), enc, 0)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !bytes.Equal(key, wantKey) || plain != wantPlain {
+		t.Logf("got: %q, want: %q", key, wantKey)
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := BreakRepeatingKeyXorContext(ctx, enc, 2); err != context.Canceled {
+		t.Logf("got: %v, want: %v", err, context.Canceled)
+		t.FailNow()
+	}
+}
+
+func TestEstimateKeysizes(t *testing.T) {
+	b64, err := ioutil.ReadFile("_testdata/6.txt")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	enc, err := base64.StdEncoding.DecodeString(string(b64))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got := EstimateKeysizes(enc, 2, 40)[0]; got.Size != 29 || got.Confidence < 0.5 {
+		t.Logf("got: %+v, want: size 29", got)
+		t.FailNow()
+	}
+
+	// Multiples of the keysize look just as good, the
+	// smallest must come first
+	plain := []byte("Now that the party is jumping, with the bass kicked in and the Vega's are pumpin'. " +
+		"Quick to the point, to the point, no faking, cooking MC's like a pound of bacon. " +
+		"Burning 'em, if you ain't quick and nimble, I go crazy when I hear a cymbal")
+	for _, key := range []string{"ICE", "YELLOW", "Ab"} {
+		enc, err := RepeatingKeyXor(plain, []byte(key))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if got := EstimateKeysizes(enc, 1, 40)[0]; got.Size != len(key) {
+			t.Logf("%s: got: %d, want: %d", key, got.Size, len(key))
+			t.Fail()
+		}
+	}
+}
+
+func TestScanSingleByteXor(t *testing.T) {
+	file, err := os.Open("_testdata/4.txt")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer file.Close()
+
+	hits, errc := ScanSingleByteXor(context.Background(), file, XorScanConfig{Encoding: HexRecords})
+	top := TopXorHits(hits, 3)
+	if err := <-errc; err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if len(top) != 3 || string(top[0].Candidates[0].Plain) != "Now that the party is jumping\n" {
+		t.Logf("got: %+v", top)
+		t.FailNow()
+	}
+
+	// The offset points back to the record
+	data, err := ioutil.ReadFile("_testdata/4.txt")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	line := data[top[0].Offset : top[0].Offset+60]
+	p, _ := hex.DecodeString(string(line))
+	if got, err := RepeatingKeyXor(p, []byte{top[0].Candidates[0].Key}); err != nil || !bytes.Equal(got, []byte("Now that the party is jumping\n")) {
+		t.Logf("offset %d points to %q", top[0].Offset, line)
+		t.FailNow()
+	}
+	t.Logf("record %d, key %#x", top[0].Record, top[0].Candidates[0].Key)
+
+	// Chi-squared scores are negative, none of them is filtered out
+	corpus, err := LoadCorpus("_testdata/aliceinwonderland.txt")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	hits, errc = ScanSingleByteXor(context.Background(), bytes.NewReader(data), XorScanConfig{
+		Encoding: HexRecords,
+		Scorer:   NewChiSquaredScorer(corpus),
+	})
+	found := false
+	for hit := range hits {
+		found = found || string(hit.Candidates[0].Plain) == "Now that the party is jumping\n"
+	}
+	if err := <-errc; err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !found {
+		t.Log("the line was filtered out")
+		t.FailNow()
+	}
+}
+
+func TestScanSingleByteXorRecords(t *testing.T) {
+	noise := bytes.Repeat([]byte{0x8f, 0x12, 0xe3, 0x07, 0x5c, 0xaa}, 4)
+	plain := []byte("Cooking MC's like a pou")
+
+	enc, err := RepeatingKeyXor(plain, []byte{'k'})
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	var stream []byte
+	for _, r := range [][]byte{noise, enc, noise} {
+		stream = append(stream, base64.StdEncoding.EncodeToString(r)...)
+	}
+
+	// The same stream wrapped at 20 columns, as base64 tools do
+	var wrapped []byte
+	for i := 0; i < len(stream); i += 20 {
+		end := i + 20
+		if end > len(stream) {
+			end = len(stream)
+		}
+		wrapped = append(append(wrapped, stream[i:end]...), '\n')
+	}
+
+	// 24 bytes of noise and 23 of text both encode to 32 characters
+	minScore := 0.05
+	for _, tt := range []struct {
+		stream []byte
+		offset int64
+	}{
+		{stream, 32},
+		{wrapped, 33},
+	} {
+		hits, errc := ScanSingleByteXor(context.Background(), bytes.NewReader(tt.stream), XorScanConfig{
+			Encoding:   Base64Records,
+			RecordSize: 32,
+			Candidates: 3,
+			MinScore:   &minScore,
+		})
+
+		var got []XorHit
+		for hit := range hits {
+			got = append(got, hit)
+		}
+		if err := <-errc; err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+
+		if len(got) != 1 || got[0].Record != 1 || got[0].Offset != tt.offset || len(got[0].Candidates) != 3 {
+			t.Logf("got: %+v, want a single hit for record 1 at %d", got, tt.offset)
+			t.FailNow()
+		}
+		if got[0].Candidates[0].Key != 'k' {
+			t.Logf("got: %#x, want: %#x", got[0].Candidates[0].Key, 'k')
+			t.FailNow()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hits, errc := ScanSingleByteXor(ctx, bytes.NewReader(stream), XorScanConfig{Encoding: Base64Records, RecordSize: 32})
+	for range hits {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Logf("got: %v, want: %v", err, context.Canceled)
+		t.FailNow()
+	}
+}
+
 func TestProblem5(t *testing.T) {
 
 	in := "Burning 'em, if you ain't quick and nimble\nI go crazy when I hear a cymbal"
 	test := "0b3637272a2b2e63622c2e69692a23693a2a3c6324202d623d63343c2a26226324272765272a282b2f20430a652e2c652a3124333a653e2b2027630c692b20283165286326302e27282f"
 
-	if enc := RepeatingKeyXor([]byte(in), []byte("ICE")); fmt.Sprintf("%x", string(enc)) != test {
+	enc, err := RepeatingKeyXor([]byte(in), []byte("ICE"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if fmt.Sprintf("%x", string(enc)) != test {
 		t.Logf("%s", test)
 		t.Logf("len:%d", len(test))
 		t.Logf("%x", fmt.Sprintf("%s", enc))
@@ -132,13 +626,28 @@ func TestProblem5(t *testing.T) {
 	// else {
 	// t.Logf("%x", string(RepeatingKeyXor([]byte("Alpha"), []byte("HEY"))))
 	// }
+
+	if _, err := RepeatingKeyXor([]byte(in), nil); !errors.Is(err, ErrKeySize) {
+		t.Logf("got: %v, want: %v", err, ErrKeySize)
+		t.FailNow()
+	}
 }
 
 func TestHammingDistance(t *testing.T) {
-	if d := ComputeHammingDistance([]byte("this is a test"), []byte("wokka wokka!!!")); d != 37 {
+	d, err := ComputeHammingDistance([]byte("this is a test"), []byte("wokka wokka!!!"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if d != 37 {
 		t.Logf("distance: %d", d)
 		t.FailNow()
 	}
+
+	if _, err := ComputeHammingDistance([]byte("this"), []byte("wokka")); err != ErrLengthMismatch {
+		t.Logf("got: %v, want: %v", err, ErrLengthMismatch)
+		t.FailNow()
+	}
 }
 
 func TestTransposeBlocks(t *testing.T) {
@@ -158,6 +667,17 @@ func TestTransposeBlocks(t *testing.T) {
 		t.Logf("test: %+v", test)
 		t.FailNow()
 	}
+
+	// A short first row, and nothing at all
+	ragged := [][]byte{[]byte("a"), []byte("bc")}
+	if out := TransposeBlocks(ragged); !reflect.DeepEqual(out, [][]byte{[]byte("ab"), []byte("c")}) {
+		t.Logf("out: %q", out)
+		t.FailNow()
+	}
+	if out := TransposeBlocks(nil); out != nil {
+		t.Logf("out: %q", out)
+		t.FailNow()
+	}
 }
 
 func TestSplit(t *testing.T) {
@@ -172,6 +692,13 @@ func TestSplit(t *testing.T) {
 	if out := Split(stream, size); !reflect.DeepEqual(test, out) {
 		t.FailNow()
 	}
+
+	for _, size := range []int{0, -1} {
+		if out := Split(stream, size); out != nil {
+			t.Logf("%d: got: %q", size, out)
+			t.FailNow()
+		}
+	}
 }
 
 func TestProblem6(t *testing.T) {
@@ -190,9 +717,26 @@ func TestProblem6(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	key, plain := BreakRepeatingKeyXor(enc)
+	key, plain, err := BreakRepeatingKeyXor(enc)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if string(key) != "Terminator X: Bring the noise" {
+		t.Logf("got: %q, want: %q", key, "Terminator X: Bring the noise")
+		t.FailNow()
+	}
 
 	t.Logf("\nkey: %s\ndata: %s", key, plain)
+
+	// Too short for any keysize
+	for _, in := range [][]byte{nil, []byte("abc")} {
+		if _, _, err := BreakRepeatingKeyXor(in); err == nil {
+			t.Logf("%q: broke it", in)
+			t.FailNow()
+		}
+	}
 }
 
 func TestProblem7(t *testing.T) {
@@ -232,7 +776,12 @@ func TestProblem8(t *testing.T) {
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		if freq := DetectAESECB(scanner.Text()); freq > best_freq {
+		freq, err := DetectAESECB(scanner.Text())
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if freq > best_freq {
 			e = scanner.Text()
 			best_freq = freq
 		}
@@ -241,6 +790,87 @@ func TestProblem8(t *testing.T) {
 	if e != test {
 		t.FailNow()
 	}
+	if best_freq != 4 {
+		t.Logf("got: %d, want: 4", best_freq)
+		t.FailNow()
+	}
+
+	if _, err := DetectAESECB("not hex"); !errors.Is(err, ErrInvalidHex) {
+		t.Logf("got: %v, want: %v", err, ErrInvalidHex)
+		t.FailNow()
+	}
 
 	t.Logf("ECB encrypted data: %s", e)
 }
+
+func TestDetectECB(t *testing.T) {
+	a := []byte("YELLOW SUBMARINE")
+	b := []byte("ORANGE SUBMARINE")
+
+	// The repeated block is the last one
+	var data []byte
+	for _, block := range [][]byte{a, b, a} {
+		data = append(data, block...)
+	}
+
+	report, err := DetectECB(data, 16)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if report.Blocks != 3 || report.RepeatedBlocks != 1 || report.MaxRepeat != 2 {
+		t.Logf("got: %+v", report)
+		t.FailNow()
+	}
+	if !reflect.DeepEqual(report.Duplicates, [][]int{{0, 32}}) {
+		t.Logf("got: %v, want: [[0 32]]", report.Duplicates)
+		t.FailNow()
+	}
+	if report.Probability < 0.99 {
+		t.Logf("got: %v, want about 1", report.Probability)
+		t.FailNow()
+	}
+
+	// Single byte blocks of random looking data collide all the time
+	noise, err := AESEncryptCBC(make([]byte, 64), a, b)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if report, _ := DetectECB(noise, 1); report.RepeatedBlocks == 0 || report.Probability > 0.9 {
+		t.Logf("got: %+v, want repetitions with a low probability", report)
+		t.FailNow()
+	}
+
+	if report, _ := DetectECB(b, 8); report.RepeatedBlocks != 0 || report.Probability != 0 {
+		t.Logf("got: %+v, want no repetitions", report)
+		t.FailNow()
+	}
+
+	if _, err := DetectECB(data, 0); err == nil {
+		t.Log("accepted a block size of 0")
+		t.FailNow()
+	}
+}
+
+func TestScanECB(t *testing.T) {
+	file, err := os.Open("_testdata/8.txt")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer file.Close()
+
+	lines, err := ScanECB(file, 16, HexRecords)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if len(lines) != 1 || lines[0].Line != 132 {
+		t.Logf("got: %+v, want line 132 only", lines)
+		t.FailNow()
+	}
+	t.Logf("line %d, duplicates at %v", lines[0].Line, lines[0].Report.Duplicates)
+}
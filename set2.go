@@ -2,11 +2,10 @@ package matasano
 
 import (
 	"bytes"
-	"crypto/aes"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"log"
 	"math"
 	"math/rand"
 	"net/url"
@@ -15,54 +14,101 @@ import (
 	"time"
 )
 
+var (
+	// ErrNotAligned is returned when padded data is
+	// not a multiple of the block size
+	ErrNotAligned = errors.New("block not aligned")
+	// ErrBadPadding is returned when the padding is malformed
+	ErrBadPadding = errors.New("incorrect padding, block may be corrupted")
+	// ErrBlockSize is returned for block sizes a padding
+	// scheme cannot handle, outside of 1 to 255
+	ErrBlockSize = errors.New("invalid block size")
+)
+
 // PadLenPKCS7 returns the length of the padding applied
-// by PadPKCS7(). If no padding is detected 0 is returned
+// by PadPKCS7(). It returns early on bad padding, see
+// PadLenPKCS7ConstantTime for a padding oracle free version
 func PadLenPKCS7(in []byte, block_size int) (int, error) {
 
+	if block_size < 1 || block_size > 255 {
+		return 0, ErrBlockSize
+	}
 	if len(in)%block_size != 0 {
-		return 0, errors.New("block not aligned")
+		return 0, ErrNotAligned
+	}
+	if len(in) == 0 {
+		return 0, ErrBadPadding
 	}
 
 	// Pick the last byte, read its value N and
 	// verify that the last N values are all equal
 	last := int(in[len(in)-1])
+	if last == 0 || last > block_size {
+		return 0, ErrBadPadding
+	}
 
 	for i := 0; i < last; i++ {
 		if in[len(in)-i-1] != in[len(in)-1] {
-			return 0, errors.New("incorrect padding, block may be corrupted")
+			return 0, ErrBadPadding
 		}
 	}
 	return last, nil
 }
 
+// PadLenPKCS7ConstantTime is PadLenPKCS7, except that it takes
+// the same time whatever the content of the last block
+func PadLenPKCS7ConstantTime(in []byte, block_size int) (int, error) {
+
+	if block_size < 1 || block_size > 255 {
+		return 0, ErrBlockSize
+	}
+	if len(in)%block_size != 0 {
+		return 0, ErrNotAligned
+	}
+	if len(in) == 0 {
+		return 0, ErrBadPadding
+	}
+
+	last := in[len(in)-1]
+	n := int(last)
+
+	// good stays 1 as long as the padding checks out
+	good := subtle.ConstantTimeLessOrEq(1, n) & subtle.ConstantTimeLessOrEq(n, block_size)
+	for i := 0; i < block_size; i++ {
+		// Only the last n bytes must equal n
+		pad := subtle.ConstantTimeLessOrEq(i+1, n)
+		eq := subtle.ConstantTimeByteEq(in[len(in)-1-i], last)
+		good &= subtle.ConstantTimeSelect(pad, eq, 1)
+	}
+
+	if good != 1 {
+		return 0, ErrBadPadding
+	}
+	return n, nil
+}
+
 // PadPKCS7 pads an arbitrary length string to any block size
-// from 1 to 255
-func PadPKCS7(in []byte, block_size int) []byte {
-
-	if len(in)%block_size == 0 {
-		// If no padding is detected, proceed and
-		// apply it now
-		if l, err := PadLenPKCS7(in, block_size); l == 0 && err != nil {
-			// In order to determine unambiguosly whether
-			// the last byte of the last plain text block
-			// was introduced via padding or not, an extra
-			// block is added. This block is made of the
-			// byte used for padding
-			for i := 0; i < block_size; i++ {
-				in = append(in, byte(16))
-			}
-		}
-		return in
+// from 1 to 255. Aligned input gets a whole block of padding,
+// so that the last byte always tells the padding length
+func PadPKCS7(in []byte, block_size int) ([]byte, error) {
+
+	// The padding length must fit in a byte
+	if block_size < 1 || block_size > 255 {
+		return nil, ErrBlockSize
 	}
 
 	pad := block_size - (len(in) % block_size)
 
+	// Copy, so as not to write past the end of the caller's slice
+	out := make([]byte, len(in), len(in)+pad)
+	copy(out, in)
+
 	// Complete the last block
 	for i := 0; i < pad; i++ {
-		in = append(in, byte(pad))
+		out = append(out, byte(pad))
 	}
 
-	return in
+	return out, nil
 }
 
 func AESEncryptECB(data, key []byte) ([]byte, error) {
@@ -70,12 +116,15 @@ func AESEncryptECB(data, key []byte) ([]byte, error) {
 	var ciphertext []byte
 	block_size := 16
 
-	blocks, err := aes.NewCipher(key)
+	blocks, err := newAESCipher(key)
 	if err != nil {
 		return nil, err
 	}
 
-	data = PadPKCS7(data, block_size)
+	data, err = PadPKCS7(data, block_size)
+	if err != nil {
+		return nil, err
+	}
 
 	ciphertext = make([]byte, len(data))
 
@@ -91,7 +140,10 @@ func AESEncryptCBC(in, key, iv []byte) ([]byte, error) {
 	var block_size int = 16
 	var cipher []byte
 
-	data := PadPKCS7(in, block_size)
+	data, err := PadPKCS7(in, block_size)
+	if err != nil {
+		return nil, err
+	}
 
 	// Xor the first block with the IV
 	enc, err := Xor(data[:block_size], iv)
@@ -141,6 +193,11 @@ func AESDecryptCBC(cipher, key, iv []byte) ([]byte, error) {
 	var block_size int = 16
 	var plain []byte
 
+	// Whole blocks only, at least one
+	if len(cipher) == 0 || len(cipher)%block_size != 0 {
+		return nil, ErrNotAligned
+	}
+
 	b, err := AESDecryptECB(cipher[:block_size], key)
 	if err != nil {
 		return nil, err
@@ -191,7 +248,7 @@ func AESGenerateKey(size int) ([]byte, error) {
 	return key, nil
 }
 
-func AESEncryptionOracle(plain []byte) ([]byte, error, string) {
+func AESEncryptionOracle(plain []byte) ([]byte, string, error) {
 
 	block_size := 16
 	keySize := 16
@@ -203,7 +260,7 @@ func AESEncryptionOracle(plain []byte) ([]byte, error, string) {
 
 	key, err := AESGenerateKey(keySize)
 	if err != nil {
-		return nil, err, ""
+		return nil, "", err
 	}
 
 	p := make([]byte, pad)
@@ -220,22 +277,22 @@ func AESEncryptionOracle(plain []byte) ([]byte, error, string) {
 		mode = "CBC"
 		iv, err := AESGenerateKey(block_size)
 		if err != nil {
-			return nil, err, ""
+			return nil, "", err
 		}
 
 		enc, err = AESEncryptCBC(plain, key, iv)
 		if err != nil {
-			return nil, err, ""
+			return nil, "", err
 		}
 	} else {
 		mode = "ECB"
 		enc, err = AESEncryptECB(plain, key)
 		if err != nil {
-			return nil, err, ""
+			return nil, "", err
 		}
 	}
 
-	return enc, nil, mode
+	return enc, mode, nil
 }
 
 // DetectionOracle pointed at an array of bytes,
@@ -248,15 +305,19 @@ func AESDetectionOracle(data []byte, l int) (string, error) {
 	// input we should be able to determine the encryption mode
 
 	size := 16
-	b := fmt.Sprintf("%x", data)
 
 	// number of repeating blocks expected for ECB
 	// the two blocks removed are the ones adjacent to the random bytes
 	m := (l / size) - 2
 
+	report, err := DetectECB(data, size)
+	if err != nil {
+		return "", err
+	}
+
 	// dont look for an exact match so that the function can be used
 	// in diffrent contexts
-	if n := DetectAESECB(b); n >= m {
+	if report.MaxRepeat >= m {
 		return "ECB", nil
 	}
 
@@ -292,58 +353,217 @@ func ECBFixedEncryptionOracle(plain, key []byte) ([]byte, error) {
 }
 
 // Challenge 12
+// ECBByteDecryption recovers the base64 encoded secret through
+// the oracle ECBFixedEncryptionOracle builds, see ECBByteAtATime
 func ECBByteDecryption(secret, fixedKey []byte) ([]byte, error) {
 
-	var str []byte
-	var blockSize int = 16
-	var decrypted []byte
+	secretText, err := base64.StdEncoding.DecodeString(string(secret))
+	if err != nil {
+		return nil, err
+	}
+
+	return ECBByteAtATime(NewECBSuffixOracle(secretText, fixedKey))
+}
+
+// ECBOracle encrypts attacker controlled input along
+// with whatever its owner keeps secret
+type ECBOracle func(input []byte) ([]byte, error)
+
+// NewECBSuffixOracle returns an oracle that computes
+// AES-ECB(input || secret, key).
+//
+// This function is used in Challenge 12
+func NewECBSuffixOracle(secret, key []byte) ECBOracle {
+	return func(input []byte) ([]byte, error) {
+		plain := make([]byte, 0, len(input)+len(secret))
+		plain = append(plain, input...)
+		plain = append(plain, secret...)
+		return ECBFixedEncryptionOracle(plain, key)
+	}
+}
+
+// repeatA returns n 'A's
+func repeatA(n int) []byte {
+	return bytes.Repeat([]byte("A"), n)
+}
+
+// BlockSize feeds the oracle longer and longer inputs: the cipher
+// text grows by a whole block at once, the block size. Only lengths
+// are looked at, it works for ECBOracle and CBCOracle alike
+func BlockSize(oracle func(input []byte) ([]byte, error)) (int, error) {
+
+	c, err := oracle(nil)
+	if err != nil {
+		return 0, err
+	}
+	start := len(c)
+
+	for i := 1; i <= 256; i++ {
+		c, err := oracle(repeatA(i))
+		if err != nil {
+			return 0, err
+		}
+		if len(c) > start {
+			return len(c) - start, nil
+		}
+	}
+
+	return 0, errors.New("cipher text never grows, not a block cipher")
+}
+
+// firstDiff returns the index of the first block where a and b differ
+func firstDiff(a, b []byte, blockSize int) int {
+	for i := 0; i < len(a)/blockSize && i < len(b)/blockSize; i++ {
+		if !bytes.Equal(a[i*blockSize:(i+1)*blockSize], b[i*blockSize:(i+1)*blockSize]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// PrefixLength returns how many bytes the oracle puts before the input.
+// Two inputs differing in their last byte first differ in the block
+// holding that byte: the filler that pushes it in the next block
+// completes the last block of the prefix. ECB and CBC both carry a
+// change on to the following blocks only, it works for either mode
+func PrefixLength(oracle func(input []byte) ([]byte, error), blockSize int) (int, error) {
+
+	query := func(fill int) (int, error) {
+		a, err := oracle(append(repeatA(fill), 'A'))
+		if err != nil {
+			return 0, err
+		}
+		b, err := oracle(append(repeatA(fill), 'B'))
+		if err != nil {
+			return 0, err
+		}
+		return firstDiff(a, b, blockSize), nil
+	}
+
+	start, err := query(0)
+	if err != nil {
+		return 0, err
+	}
+	if start < 0 {
+		return 0, errors.New("input does not change the cipher text")
+	}
+
+	for fill := 1; fill <= blockSize; fill++ {
+		b, err := query(fill)
+		if err != nil {
+			return 0, err
+		}
+		if b > start {
+			return (start+1)*blockSize - fill, nil
+		}
+	}
+
+	return 0, errors.New("failed to find the prefix length")
+}
+
+// IsECB feeds the oracle three blocks worth of the same byte: whatever
+// comes before them, ECB turns at least two of them into the same
+// cipher text block
+func IsECB(oracle ECBOracle, blockSize int) (bool, error) {
+
+	c, err := oracle(repeatA(3 * blockSize))
+	if err != nil {
+		return false, err
+	}
+
+	report, err := DetectECB(c, blockSize)
+	if err != nil {
+		return false, err
+	}
+
+	return report.RepeatedBlocks > 0, nil
+}
+
+// ECBSuffixLength returns how many bytes the oracle appends to the
+// input. Padding always adds at least a byte: the input length that
+// makes the cipher text grow is the one filling the last block
+func ECBSuffixLength(oracle ECBOracle, blockSize int) (int, error) {
+
+	c, err := oracle(nil)
+	if err != nil {
+		return 0, err
+	}
+	start := len(c)
+
+	for i := 1; i <= blockSize; i++ {
+		c, err := oracle(repeatA(i))
+		if err != nil {
+			return 0, err
+		}
+		if len(c) > start {
+			return start - i, nil
+		}
+	}
+
+	return 0, errors.New("cipher text never grows")
+}
+
+// ECBByteAtATime recovers the secret an ECB oracle appends to the input,
+// knowing nothing but the oracle. Block size, mode and secret length are
+// found first. Then, padding the input so that the next unknown byte is
+// the last of a block, that block is encrypted along with 15 known bytes:
+// trying the 256 values of the last byte until the cipher text matches
+// gives the byte away
+func ECBByteAtATime(oracle ECBOracle) ([]byte, error) {
 
-	// TODO
-	// 	the input is indeed correct
-	secretText := make([]byte, base64.StdEncoding.DecodedLen(len(secret)))
-	_, err := base64.StdEncoding.Decode(secretText, secret)
+	blockSize, err := BlockSize(oracle)
 	if err != nil {
 		return nil, err
 	}
 
-	// for some reasons, the last block does not get decrypted
-	for x := 0; x < len(secretText)/blockSize; x++ {
-		// Slide bytes one by one for each block
-		for i := 1; i <= blockSize; i++ {
-			str = make([]byte, blockSize-i)
-			for j := 0; j < len(str); j++ {
-				str[j] = byte('A')
-			}
+	ecb, err := IsECB(oracle, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	if !ecb {
+		return nil, errors.New("oracle does not use ECB")
+	}
 
-			payload := append(str, secretText[x*blockSize:(x+1)*blockSize]...)
+	n, err := ECBSuffixLength(oracle, blockSize)
+	if err != nil {
+		return nil, err
+	}
 
-			want, err := ECBFixedEncryptionOracle(payload, fixedKey)
-			if err != nil {
-				return nil, err
-			}
+	// Known bytes, starting with a block of padding
+	known := repeatA(blockSize - 1)
 
-			// now comes the bruteforce part:
-			// try every possible byte and see which one matches the output
-			for k := 0; k < 255; k++ {
-				payload[blockSize-1] = byte(k)
+	for j := 0; j < n; j++ {
+		pad := repeatA(blockSize - 1 - j%blockSize)
+		b := j / blockSize
 
-				got, err := ECBFixedEncryptionOracle(payload, fixedKey)
-				if err != nil {
-					return nil, err
-				}
+		c, err := oracle(pad)
+		if err != nil {
+			return nil, err
+		}
+		target := c[b*blockSize : (b+1)*blockSize]
 
-				// fmt.Printf("str: %v\nwant: %v\ngot: %v\033[F\033[F", payload[x*blockSize:(x+1)*blockSize], want[x*blockSize:(x+1)*blockSize], got[x*blockSize:(x+1)*blockSize])
+		// The last blockSize-1 known bytes, followed by the guess
+		guess := make([]byte, blockSize)
+		copy(guess, known[len(known)-blockSize+1:])
 
-				if bytes.Equal(got[:blockSize], want[:blockSize]) {
-					decrypted = append(decrypted, byte(k))
-				}
+		found := false
+		for k := 0; k < 256 && !found; k++ {
+			guess[blockSize-1] = byte(k)
+			c, err := oracle(guess)
+			if err != nil {
+				return nil, err
 			}
+			if bytes.Equal(c[:blockSize], target) {
+				known = append(known, byte(k))
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no match for byte %d", j)
 		}
-		// fmt.Println(x)
 	}
-	fmt.Printf("%s\n", string(decrypted[:]))
 
-	return decrypted, nil
+	return known[blockSize-1:], nil
 }
 
 // A cut-and-paste attack is an assault on the integrity of a security system
@@ -362,23 +582,131 @@ func ParseParams(params string) (url.Values, error) {
 	return v, nil
 }
 
+// CookiePair is a key=value pair of a cookie
+type CookiePair struct {
+	Key   string
+	Value string
+}
+
+// Cookie is an ordered list of key=value pairs
+type Cookie []CookiePair
+
+// Get returns the value of the first pair with the
+// given key, the empty string if there is none
+func (c Cookie) Get(key string) string {
+	for _, p := range c {
+		if p.Key == key {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// CookieCodec encodes and decodes cookies, Separator going between
+// pairs. Metacharacters (%, =, & and ;) and spaces are percent-encoded,
+// as in "comment1=cooking%20MCs"
+type CookieCodec struct {
+	Separator byte
+}
+
+var (
+	// AmpersandCodec separates pairs with &, as profiles do
+	AmpersandCodec = CookieCodec{Separator: '&'}
+	// SemicolonCodec separates pairs with ;, as comment cookies do
+	SemicolonCodec = CookieCodec{Separator: ';'}
+)
+
+const cookieEscaped = "%=&; "
+
+func cookieEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(cookieEscaped, s[i]) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", s[i])
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// cookieUnescape rejects malformed escapes and
+// metacharacters that should have been escaped
+func cookieUnescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '%':
+			if i+2 >= len(s) {
+				return "", errors.New("truncated escape")
+			}
+			v, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", errors.New("invalid escape")
+			}
+			b.WriteByte(byte(v))
+			i += 2
+		case '=', '&', ';':
+			return "", errors.New("unescaped metacharacter")
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// Encode returns the pairs of cookie, in order
+func (c CookieCodec) Encode(cookie Cookie) string {
+	pairs := make([]string, len(cookie))
+	for i, p := range cookie {
+		pairs[i] = cookieEscape(p.Key) + "=" + cookieEscape(p.Value)
+	}
+	return strings.Join(pairs, string(c.Separator))
+}
+
+// Decode parses what Encode returns: every pair must have a non
+// empty key, exactly one = and nothing left unescaped
+func (c CookieCodec) Decode(s string) (Cookie, error) {
+	var cookie Cookie
+	for _, pair := range strings.Split(s, string(c.Separator)) {
+		i := strings.IndexByte(pair, '=')
+		if i < 0 {
+			return nil, errors.New("missing = in pair")
+		}
+
+		key, err := cookieUnescape(pair[:i])
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, errors.New("empty key")
+		}
+
+		value, err := cookieUnescape(pair[i+1:])
+		if err != nil {
+			return nil, err
+		}
+
+		cookie = append(cookie, CookiePair{key, value})
+	}
+	return cookie, nil
+}
+
+// Challenge 13
+// ProfileFor eats the metacharacters of the email
+// and returns email=foo@bar.com&uid=10&role=user
 func ProfileFor(email string) (string, error) {
-	v := url.Values{}
 	// uid := strconv.Itoa(rand.Intn(99))
 	uid := 10
 
 	email = strings.Replace(email, "&", "", -1)
 	email = strings.Replace(email, "=", "", -1)
 
-	v.Add("email", email)
-	v.Add("uid", strconv.Itoa(uid))
-	v.Add("role", "user")
-
-	str, err := url.QueryUnescape(v.Encode())
-	if err != nil {
-		return "", nil
-	}
-	return str, nil
+	return AmpersandCodec.Encode(Cookie{
+		{"email", email},
+		{"uid", strconv.Itoa(uid)},
+		{"role", "user"},
+	}), nil
 }
 
 func EncryptCookie(cookie string, key []byte) ([]byte, error) {
@@ -398,17 +726,17 @@ func DecryptCookie(enc, key []byte) ([]byte, error) {
 		return nil, err
 	}
 
-	u, err := ParseParams(string(plain))
+	plain, err = UnpadPKCS7(plain, 16)
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := url.QueryUnescape(u.Encode())
+	cookie, err := AmpersandCodec.Decode(string(plain))
 	if err != nil {
 		return nil, err
 	}
 
-	return []byte(user), nil
+	return []byte(AmpersandCodec.Encode(cookie)), nil
 }
 
 // This function is what an attacker that intercepted
@@ -421,61 +749,149 @@ func SetAdminCookie() ([]byte, error) {
 	// ie: a key agreed upon with a secure channel obtained
 	// from the use of asymmetric cryptography”
 	keySize := 16
-	blockSize := 16
 	key, err := AESGenerateKey(keySize)
 	if err != nil {
 		return nil, err
 	}
 
-	// cipher 1:
-	//         block1: email=AAAAAAAAAA
-	//         block2: AAAAAAAAAA&role=
-	//         block3: user&uid=10
-	//
-	// create a new cipher made of
-	//         b1 + b2 + b5
-	// cipher 2:
-	//         block4: email=AAAAAAAAAA
-	//         block5: admin&role=user
-	//                         &uid=10
-	payload1 := "AAAAAAAAAAaaaaaaaaaa"
+	// The profile of any user, as they see it
+	layout := Cookie{{"email", ""}, {"uid", "10"}, {"role", "user"}}
 
-	c1, err := ProfileFor(string(payload1))
+	forged, err := ForgeECBProfile(NewProfileOracle(key), layout, "role", "admin")
 	if err != nil {
 		return nil, err
 	}
 
-	cipherPart1, err := EncryptCookie(c1, key)
-	if err != nil {
-		return nil, err
+	return DecryptCookie(forged, key)
+}
+
+// ProfileOracle returns the encrypted profile of a user,
+// the way a server handing out cookies would
+type ProfileOracle func(email string) ([]byte, error)
+
+// NewProfileOracle returns an oracle that encrypts
+// ProfileFor(email) with EncryptCookie.
+//
+// This function is used in Challenge 13
+func NewProfileOracle(key []byte) ProfileOracle {
+	return func(email string) ([]byte, error) {
+		profile, err := ProfileFor(email)
+		if err != nil {
+			return nil, err
+		}
+		return EncryptCookie(profile, key)
+	}
+}
+
+// ForgeECBProfile builds an encrypted profile where field is set to value
+// with the oracle alone. Nothing close to a separator can be matched one
+// byte at a time, ProfileFor eats the separators of the email: where the
+// field is cannot be told from the cipher texts, and must come from the
+// caller. layout holds the pairs of a profile, in order, as its owner
+// sees it: the value of the "email" pair is ignored, the others must be
+// right. Block size, and the lengths of what comes before and after the
+// email, are worked out from the cipher texts and checked against it.
+//
+// value and its padding get a block of their own, replacing everything
+// that follows "field=": the blocks up to there come from an email of the
+// right length if the field comes after the email, from the blocks before
+// the email otherwise. A field before the email can only be forged if
+// it ends a block, and value cannot hold the characters ProfileFor
+// strips or escapes
+func ForgeECBProfile(oracle ProfileOracle, layout Cookie, field, value string) ([]byte, error) {
+
+	if cookieEscape(value) != value {
+		return nil, errors.New("value does not survive the profile encoding")
 	}
 
-	payload2 := "AAAAAAAAAAadmin"
+	email, target := -1, -1
+	for i, p := range layout {
+		if p.Key == "email" && email < 0 {
+			email = i
+		}
+		if p.Key == field && target < 0 {
+			target = i
+		}
+	}
+	if email < 0 {
+		return nil, errors.New("no email in the layout")
+	}
+	if target < 0 || target == email {
+		return nil, errors.New("field not found in the layout")
+	}
 
-	c2, err := ProfileFor(string(payload2))
+	// upTo encodes the pairs of the layout from i to j, cut right after
+	// "key=" of the last one
+	upTo := func(i, j int) string {
+		pairs := append(Cookie{}, layout[i:j]...)
+		return AmpersandCodec.Encode(append(pairs, CookiePair{layout[j].Key, ""}))
+	}
+
+	ecb := func(input []byte) ([]byte, error) {
+		return oracle(string(input))
+	}
+
+	blockSize, err := BlockSize(ecb)
 	if err != nil {
 		return nil, err
 	}
-
-	cipherPart2, err := EncryptCookie(c2, key)
+	prefix, err := PrefixLength(ecb, blockSize)
+	if err != nil {
+		return nil, err
+	}
+	rest, err := ECBSuffixLength(ecb, blockSize)
 	if err != nil {
 		return nil, err
 	}
 
-	cutpasted := make([]byte, blockSize*4)
-	copy(cutpasted[:blockSize*2], cipherPart1[:blockSize*2])
-	copy(cutpasted[blockSize*2:blockSize*3], cipherPart2[blockSize:blockSize*2])
-	copy(cutpasted[blockSize*3:blockSize*4], cipherPart1[blockSize*2:blockSize*3])
+	after := ""
+	if email+1 < len(layout) {
+		after = "&" + AmpersandCodec.Encode(layout[email+1:])
+	}
+	if len(upTo(0, email)) != prefix || len(after) != rest-prefix {
+		return nil, errors.New("layout does not match the profile")
+	}
 
-	// In a real world scenario, before knowing the
-	// contents of each block, we would have to leverage
-	// one byte at a time decryption (problem 12)
-	plain, err := DecryptCookie(cutpasted, key)
+	// Blocks up to "field="
+	var head []byte
+	if target < email {
+		end := len(upTo(0, target))
+		if end%blockSize != 0 {
+			return nil, errors.New("field before the email does not end a block")
+		}
+		c, err := ecb(nil)
+		if err != nil {
+			return nil, err
+		}
+		head = c[:end]
+	} else {
+		// The email gets long enough to end a block right after it
+		end := prefix + len("&"+upTo(email+1, target))
+		n := (blockSize - end%blockSize) % blockSize
+		c, err := ecb(repeatA(n))
+		if err != nil {
+			return nil, err
+		}
+		head = c[:end+n]
+	}
+
+	// Blocks holding the new value
+	padded, err := PadPKCS7([]byte(value), blockSize)
+	if err != nil {
+		return nil, err
+	}
+	fill := (blockSize - prefix%blockSize) % blockSize
+	c, err := ecb(append(repeatA(fill), padded...))
 	if err != nil {
 		return nil, err
 	}
+	tail := c[prefix+fill : prefix+fill+len(padded)]
 
-	return plain, nil
+	forged := make([]byte, 0, len(head)+len(tail))
+	forged = append(forged, head...)
+	forged = append(forged, tail...)
+
+	return forged, nil
 }
 
 // Round to the multiple of a desired unit
@@ -488,60 +904,175 @@ func Round(x, unit float64) float64 {
 // blocks to detect the beginning of the attacker controlled string
 func ECBByteDecryptionHard(secret, fixedKey []byte) ([]byte, error) {
 
-	blockSize := 16
+	secretText, err := base64.StdEncoding.DecodeString(string(secret))
+	if err != nil {
+		return nil, err
+	}
+
 	rand.Seed(time.Now().UTC().UnixNano())
-	rndLength := rand.Intn(48)
+	prefix := make([]byte, rand.Intn(48))
+	rand.Read(prefix)
 
-	rndBytes := make([]byte, rndLength)
-	rand.Read(rndBytes)
+	return ECBByteAtATimePrefix(NewECBPrefixOracle(prefix, secretText, fixedKey))
+}
 
-	i, err := skipBadBlocks(rndBytes)
-	if err != nil {
-		return nil, err
-	} else if Round(float64(rndLength), float64(blockSize)) != float64(i*blockSize) {
-		// fmt.Println(rndLength)
-		// fmt.Printf("rnd bytes enc: %v, skip: %v", Round(float64(rndLength), float64(blockSize)), i*blockSize)
-		return nil, errors.New("failed to skip random bytes")
+// NewECBPrefixOracle returns an oracle that computes
+// AES-ECB(prefix || input || secret, key).
+//
+// This function is used in Challenge 14
+func NewECBPrefixOracle(prefix, secret, key []byte) ECBOracle {
+	suffix := NewECBSuffixOracle(secret, key)
+	return func(input []byte) ([]byte, error) {
+		plain := make([]byte, 0, len(prefix)+len(input))
+		plain = append(plain, prefix...)
+		plain = append(plain, input...)
+		return suffix(plain)
 	}
+}
 
-	plain, err := ECBByteDecryption([]byte(secret), fixedKey)
-	if err != nil {
-		return nil, err
+// NewECBRandomPrefixOracle is like NewECBPrefixOracle, except that every
+// query gets a fresh random prefix, shorter than max bytes. A max of
+// zero or less means no prefix at all
+func NewECBRandomPrefixOracle(secret, key []byte, max int) ECBOracle {
+	suffix := NewECBSuffixOracle(secret, key)
+	return func(input []byte) ([]byte, error) {
+		n := 0
+		if max > 0 {
+			n = rand.Intn(max)
+		}
+		plain := make([]byte, n, n+len(input))
+		rand.Read(plain)
+		plain = append(plain, input...)
+		return suffix(plain)
 	}
-	return plain, nil
 }
 
-func skipBadBlocks(rndBytes []byte) (int, error) {
+// ecbSentinel is the block sent twice to find where
+// the attacker controlled input begins
+var ecbSentinel = []byte{
+	0xf0, 0xe1, 0xd2, 0xc3, 0xb4, 0xa5, 0x96, 0x87,
+	0x78, 0x69, 0x5a, 0x4b, 0x3c, 0x2d, 0x1e, 0x0f,
+}
 
-	keySize := 16
-	blockSize := 16
+// ECBBlockSizeNoisy finds the block size of an oracle whose cipher text
+// length also depends on something other than the input: every length
+// is a multiple of the block size, so is their greatest common divisor
+func ECBBlockSizeNoisy(oracle ECBOracle) (int, error) {
 
-	key, err := AESGenerateKey(keySize)
-	if err != nil {
-		return -1, err
+	gcd := 0
+	for i := 0; i < 64; i++ {
+		c, err := oracle(repeatA(i))
+		if err != nil {
+			return 0, err
+		}
+		a, b := gcd, len(c)
+		for b != 0 {
+			a, b = b, a%b
+		}
+		gcd = a
 	}
 
-	block := make([]byte, blockSize*3)
-	for i := 0; i < len(block); i++ {
-		block[i] = byte('A')
+	if gcd < 2 {
+		return 0, errors.New("cipher text length has no block structure")
 	}
 
-	c, err := AESEncryptECB(append(rndBytes, block...), key)
-	if err != nil {
-		return -1, err
+	return gcd, nil
+}
+
+// StripECBPrefix turns an oracle that prepends a prefix, fixed or not,
+// into one that does not. Each query is preceded by some filler and two
+// sentinel blocks: when the filler completes the last block of the prefix
+// the sentinels encrypt to the same block, and whatever follows them is
+// the encryption of the query alone. Fillers are tried in turn, starting
+// from the last one that worked, at most attempts times
+func StripECBPrefix(oracle ECBOracle, blockSize, attempts int) (ECBOracle, error) {
+
+	sentinel := make([]byte, blockSize)
+	for i := range sentinel {
+		sentinel[i] = ecbSentinel[i%len(ecbSentinel)]
 	}
 
-	for i := 0; i < len(c)/blockSize; i++ {
-		if bytes.Equal(c[blockSize*i:blockSize*(i+1)], c[blockSize*(i+1):blockSize*(i+2)]) {
-			return i, nil
+	// pair returns the index of the first two consecutive
+	// blocks of c equal to block, -1 if there are none
+	pair := func(c, block []byte) int {
+		for b := 0; b+2 <= len(c)/blockSize; b++ {
+			if bytes.Equal(c[b*blockSize:(b+1)*blockSize], block) &&
+				bytes.Equal(c[(b+1)*blockSize:(b+2)*blockSize], block) {
+				return b
+			}
 		}
+		return -1
 	}
-	return 0, nil
+
+	// Learn the encryption of the sentinel. A prefix may repeat
+	// blocks on its own: those show up without the sentinels too
+	var encrypted []byte
+	fill := 0
+	for i := 0; i < attempts && encrypted == nil; i++ {
+		fill = i % blockSize
+		filler := repeatA(fill)
+
+		c, err := oracle(append(append(filler, sentinel...), sentinel...))
+		if err != nil {
+			return nil, err
+		}
+		plain, err := oracle(filler)
+		if err != nil {
+			return nil, err
+		}
+
+		for b := 0; b+2 <= len(c)/blockSize; b++ {
+			block := c[b*blockSize : (b+1)*blockSize]
+			if pair(c[b*blockSize:], block) == 0 && !bytes.Contains(plain, block) {
+				encrypted = block
+				break
+			}
+		}
+	}
+	if encrypted == nil {
+		return nil, errors.New("failed to align sentinel blocks")
+	}
+
+	return func(input []byte) ([]byte, error) {
+		for i := 0; i < attempts; i++ {
+			query := repeatA(fill)
+			query = append(query, sentinel...)
+			query = append(query, sentinel...)
+			query = append(query, input...)
+
+			c, err := oracle(query)
+			if err != nil {
+				return nil, err
+			}
+			if b := pair(c, encrypted); b >= 0 {
+				return c[(b+2)*blockSize:], nil
+			}
+			fill = (fill + 1) % blockSize
+		}
+		return nil, errors.New("failed to align sentinel blocks")
+	}, nil
+}
+
+// ECBByteAtATimePrefix recovers the secret an ECB oracle appends to the
+// input when it also prepends a prefix, of fixed or random length: once
+// the prefix is stripped, see StripECBPrefix, it is just ECBByteAtATime
+func ECBByteAtATimePrefix(oracle ECBOracle) ([]byte, error) {
+
+	blockSize, err := ECBBlockSizeNoisy(oracle)
+	if err != nil {
+		return nil, err
+	}
+
+	stripped, err := StripECBPrefix(oracle, blockSize, 64*blockSize)
+	if err != nil {
+		return nil, err
+	}
+
+	return ECBByteAtATime(stripped)
 }
 
 // Challenge 15
-func UnpadPKCS7(str []byte) ([]byte, error) {
-	blockSize := 16
+func UnpadPKCS7(str []byte, blockSize int) ([]byte, error) {
 	padLen, err := PadLenPKCS7(str, blockSize)
 	if err != nil {
 		return nil, err
@@ -550,14 +1081,201 @@ func UnpadPKCS7(str []byte) ([]byte, error) {
 	return str[:len(str)-padLen], nil
 }
 
+// Padding is a block cipher padding scheme
+type Padding interface {
+	// Pad extends in to a multiple of the block size
+	Pad(in []byte) ([]byte, error)
+	// Unpad checks and removes the padding
+	Unpad(in []byte) ([]byte, error)
+}
+
+// checkPadded validates the block size and the length of padded data
+func checkPadded(in []byte, blockSize int) error {
+	if blockSize < 1 || blockSize > 255 {
+		return ErrBlockSize
+	}
+	if len(in)%blockSize != 0 {
+		return ErrNotAligned
+	}
+	if len(in) == 0 {
+		return ErrBadPadding
+	}
+	return nil
+}
+
+// padWith appends n bytes to a copy of in, the last one being
+// last and the others whatever fill returns for their index
+func padWith(in []byte, n int, last byte, fill func(i int) byte) []byte {
+	out := make([]byte, len(in), len(in)+n)
+	copy(out, in)
+	for i := 0; i < n-1; i++ {
+		out = append(out, fill(i))
+	}
+	return append(out, last)
+}
+
+// PKCS7Padding pads with N bytes of value N, see PadPKCS7.
+// Padding is checked in constant time
+type PKCS7Padding struct {
+	BlockSize int
+}
+
+// Pad adds 1 to BlockSize bytes of padding, see PadPKCS7
+func (p PKCS7Padding) Pad(in []byte) ([]byte, error) {
+	return PadPKCS7(in, p.BlockSize)
+}
+
+// Unpad removes the padding, checking every byte of it
+func (p PKCS7Padding) Unpad(in []byte) ([]byte, error) {
+	n, err := PadLenPKCS7ConstantTime(in, p.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	return in[:len(in)-n], nil
+}
+
+// ANSIX923Padding pads with N-1 zeros followed by N.
+// Padding is checked in constant time
+type ANSIX923Padding struct {
+	BlockSize int
+}
+
+// Pad adds 1 to BlockSize bytes of padding
+func (p ANSIX923Padding) Pad(in []byte) ([]byte, error) {
+	if p.BlockSize < 1 || p.BlockSize > 255 {
+		return nil, ErrBlockSize
+	}
+	n := p.BlockSize - len(in)%p.BlockSize
+	return padWith(in, n, byte(n), func(int) byte { return 0 }), nil
+}
+
+// Unpad removes the padding, checking that
+// the bytes before the length are zeros
+func (p ANSIX923Padding) Unpad(in []byte) ([]byte, error) {
+	if err := checkPadded(in, p.BlockSize); err != nil {
+		return nil, err
+	}
+
+	n := int(in[len(in)-1])
+	good := subtle.ConstantTimeLessOrEq(1, n) & subtle.ConstantTimeLessOrEq(n, p.BlockSize)
+	for i := 1; i < p.BlockSize; i++ {
+		// The n-1 bytes before the last must be zero
+		pad := subtle.ConstantTimeLessOrEq(i+1, n)
+		zero := subtle.ConstantTimeByteEq(in[len(in)-1-i], 0)
+		good &= subtle.ConstantTimeSelect(pad, zero, 1)
+	}
+
+	if good != 1 {
+		return nil, ErrBadPadding
+	}
+	return in[:len(in)-n], nil
+}
+
+// ISO7816Padding pads with 0x80 followed by as many zeros as needed,
+// as ISO/IEC 7816-4 does. Padding is checked in constant time
+type ISO7816Padding struct {
+	BlockSize int
+}
+
+// Pad adds 1 to BlockSize bytes of padding
+func (p ISO7816Padding) Pad(in []byte) ([]byte, error) {
+	if p.BlockSize < 1 || p.BlockSize > 255 {
+		return nil, ErrBlockSize
+	}
+	n := p.BlockSize - len(in)%p.BlockSize
+	out := padWith(in, n, 0, func(int) byte { return 0 })
+	out[len(in)] = 0x80
+	return out, nil
+}
+
+// Unpad removes the trailing zeros and
+// the marker, which must be in the last block
+func (p ISO7816Padding) Unpad(in []byte) ([]byte, error) {
+	if err := checkPadded(in, p.BlockSize); err != nil {
+		return nil, err
+	}
+
+	// Scanning the last block backwards, the first
+	// byte that is not zero must be the marker
+	good, found, n := 1, 0, 0
+	for i := 0; i < p.BlockSize; i++ {
+		b := in[len(in)-1-i]
+		first := (1 - found) & (1 - subtle.ConstantTimeByteEq(b, 0))
+		good &= subtle.ConstantTimeSelect(first, subtle.ConstantTimeByteEq(b, 0x80), 1)
+		n = subtle.ConstantTimeSelect(first, i+1, n)
+		found |= first
+	}
+
+	if good&found != 1 {
+		return nil, ErrBadPadding
+	}
+	return in[:len(in)-n], nil
+}
+
+// ISO10126Padding pads with N-1 random bytes followed by N.
+// Only the last byte can be checked
+type ISO10126Padding struct {
+	BlockSize int
+}
+
+// Pad adds 1 to BlockSize bytes of padding
+func (p ISO10126Padding) Pad(in []byte) ([]byte, error) {
+	if p.BlockSize < 1 || p.BlockSize > 255 {
+		return nil, ErrBlockSize
+	}
+	n := p.BlockSize - len(in)%p.BlockSize
+	return padWith(in, n, byte(n), func(int) byte { return byte(rand.Intn(256)) }), nil
+}
+
+// Unpad removes as many bytes as the last one
+// says, which must be from 1 to BlockSize
+func (p ISO10126Padding) Unpad(in []byte) ([]byte, error) {
+	if err := checkPadded(in, p.BlockSize); err != nil {
+		return nil, err
+	}
+
+	n := int(in[len(in)-1])
+	if n == 0 || n > p.BlockSize {
+		return nil, ErrBadPadding
+	}
+	return in[:len(in)-n], nil
+}
+
+// ZeroPadding pads with zeros, aligned input is left as is. Unpad
+// strips every trailing zero, those of the data too: use it for data
+// that cannot end with one
+type ZeroPadding struct {
+	BlockSize int
+}
+
+// Pad adds 0 to BlockSize-1 zeros
+func (p ZeroPadding) Pad(in []byte) ([]byte, error) {
+	if p.BlockSize < 1 || p.BlockSize > 255 {
+		return nil, ErrBlockSize
+	}
+	n := (p.BlockSize - len(in)%p.BlockSize) % p.BlockSize
+	out := make([]byte, len(in)+n)
+	copy(out, in)
+	return out, nil
+}
+
+// Unpad removes every trailing zero
+func (p ZeroPadding) Unpad(in []byte) ([]byte, error) {
+	if p.BlockSize < 1 || p.BlockSize > 255 {
+		return nil, ErrBlockSize
+	}
+	if len(in)%p.BlockSize != 0 {
+		return nil, ErrNotAligned
+	}
+	return bytes.TrimRight(in, "\x00"), nil
+}
+
 // Creating a function for each bitwise operetor is whack to say the least,
 // functional shennaningans or just decent programming practice would do alot here
 func Or(s1, s2 []byte) ([]byte, error) {
 
 	if len(s1) != len(s2) {
-		log.Printf("s1: %v, len s1: %d", s1, len(s1))
-		log.Printf("s2: %v, len s2: %d", s2, len(s2))
-		log.Fatal("Different length buffers\n")
+		return nil, ErrLengthMismatch
 	}
 
 	res := make([]byte, len(s1))
@@ -573,102 +1291,145 @@ func Or(s1, s2 []byte) ([]byte, error) {
 //
 // This function is used in Challenge 16
 func GenerateCookieCBC(input, key, iv []byte) ([]byte, error) {
-	// blockSize := 16
-	prefixStr := []byte("comment1=cooking%20MCs&userdata=")
-	suffixStr := []byte("&comment2=%20like%20a%20pound%20of%20bacon")
-
-	input = []byte(strings.Replace(string(input), "&", "", -1))
-	input = []byte(strings.Replace(string(input), "=", "", -1))
-
-	prefix := make([]byte, len(prefixStr))
-	suffix := make([]byte, len(suffixStr))
-	copy(prefix, prefixStr)
-	copy(suffix, suffixStr)
-
-	// fmt.Printf("len prefix: %d\n", len(prefixStr))
-	// fmt.Printf("len prefix: %d\n", len(prefix))
-	//
-	// fmt.Printf("len suffix: %d\n", len(suffixStr))
-	// fmt.Printf("len suffix: %d\n", len(suffix))
 
-	// if hex.Encode(prefix, prefixStr) == 0 {
-	//         return nil, errors.New("bad hex encoding")
-	// }
-	//
-	// if hex.Encode(suffix, suffix) == 0 {
-	//         return nil, errors.New("bad hex encoding")
-	// }
+	// comment1=cooking%20MCs;userdata=...;comment2=%20like%20a%20pound%20of%20bacon
+	plain := SemicolonCodec.Encode(Cookie{
+		{"comment1", "cooking MCs"},
+		{"userdata", string(input)},
+		{"comment2", " like a pound of bacon"},
+	})
 
-	part := append(prefix, input...)
-	plain := append(part, suffix...)
-
-	// fmt.Printf("%s\n", plain)
-	// fmt.Printf("%v\n", plain)
-	// fmt.Printf("%d\n", len(plain))
-	// plain = PadPKCS7(plain, blockSize)
-	// fmt.Printf("%v\n", plain)
-	// fmt.Printf("%d\n", len(plain))
-
-	cipher, err := AESEncryptCBC(plain, key, iv)
+	cipher, err := AESEncryptCBC([]byte(plain), key, iv)
 	if err != nil {
 		return nil, err
 	}
 
-	// xx, err := AESDecryptCBC(cipher, key, iv)
-	// if err != nil {
-	//         return nil, err
-	// }
-	// fmt.Printf("%s\n", string(xx))
 	return cipher, nil
 }
 
-// BitflipCookieCBC takes a cipher created by GenerateCookieCBC
-// and tries to	privilege escalate by bit flipping CBC blocks
-// and setting the string "admin=true"
-func BitflipCookieCBC(cipher, key, iv []byte) (bool, error) {
+// BitflipCookieCBC forges a cookie with admin=true out of the
+// GenerateCookieCBC oracle keyed with key and iv, flipping the bits of a
+// sacrificial block with CBCBitflip, and tells whether the server takes
+// it as an admin's. The garbage the sacrificial block decrypts to may not
+// decode: other sacrificial blocks are tried until the server takes one
+func BitflipCookieCBC(key, iv []byte) (bool, error) {
 
-	blockSize := 16
-	// block to be used to forge a CBC block with admin privileges
-	corruption := make([]byte, len(cipher))
-	// All there is to do to turn the question marks to the
-	// desired characters is set to zero the correct bit
-	//     ; => 00111011
-	//     ? => 00111111
-	//     = => 00111101
+	accept := func(cipher []byte) bool {
+		admin, _ := AdminCookieCBC(cipher, key, iv)
+		return admin
+	}
 
-	xored := []byte("&admin=true")
+	forged, err := CBCBitflip(NewCBCCookieOracle(key, iv), []byte(";admin=true"), accept)
+	if err != nil {
+		return false, err
+	}
 
-	copy(corruption[blockSize:blockSize+len(xored)], xored)
+	return AdminCookieCBC(forged, key, iv)
+}
 
-	// Set this straight and the exploit should work
-	// All this hackery is because I want to do this:
-	// 	P' == (cipher XOR corruption) == (plaintext XOR corruption)
+// isAdminCookie decodes a comment cookie and checks for admin=true
+func isAdminCookie(p []byte) (bool, error) {
 
-	bitflipped, err := Xor(cipher, corruption)
+	v, err := SemicolonCodec.Decode(string(p))
 	if err != nil {
 		return false, err
 	}
 
-	p, err := AESDecryptCBC(bitflipped, key, iv)
+	if v.Get("admin") == "true" {
+		return true, nil
+	} else {
+		return false, errors.New("not admin")
+	}
+}
+
+// AdminCookieCBC is what the server does with a cookie
+// created by GenerateCookieCBC: is it an admin's?
+//
+// This function is used in Challenge 16
+func AdminCookieCBC(cipher, key, iv []byte) (bool, error) {
+
+	p, err := AESDecryptCBC(cipher, key, iv)
 	if err != nil {
 		return false, err
 	}
 
-	p, err = UnpadPKCS7(p)
+	p, err = UnpadPKCS7(p, 16)
 	if err != nil {
 		return false, err
 	}
 
-	log.Printf("forged cookie: %s", string(p))
+	return isAdminCookie(p)
+}
+
+// CBCOracle encrypts attacker controlled input, along with whatever
+// its owner adds to it, with a fixed key and IV
+type CBCOracle func(input []byte) ([]byte, error)
+
+// NewCBCCookieOracle returns an oracle that calls GenerateCookieCBC
+//
+// This function is used in Challenge 16
+func NewCBCCookieOracle(key, iv []byte) CBCOracle {
+	return func(input []byte) ([]byte, error) {
+		return GenerateCookieCBC(input, key, iv)
+	}
+}
+
+// CBCBitflip forges a cipher text whose plain text holds injection, which
+// never goes through the oracle, so neither do its metacharacters. Once
+// the input is aligned, a sacrificial block is followed by a block of
+// known 'A's ending in the injection: flipping the bits of the sacrificial
+// cipher text block turns one into the other, and the sacrificial block
+// into garbage. Should the garbage break the cookie, other sacrificial
+// blocks give other garbage: accept, if not nil, stands for the server
+// taking the forged cookie or not.
+//
+// A single block is flipped, so injection can be at most a block long:
+// longer injections get an error rather than a cookie cut short
+func CBCBitflip(oracle CBCOracle, injection []byte, accept func(cipher []byte) bool) ([]byte, error) {
 
-	v, err := url.ParseQuery(string(p))
+	blockSize, err := BlockSize(oracle)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+	if len(injection) > blockSize {
+		return nil, errors.New("injection longer than a block")
 	}
 
-	if v.Get("admin") == "true" {
-		return true, nil
-	} else {
-		return false, errors.New("not admin")
+	prefix, err := PrefixLength(oracle, blockSize)
+	if err != nil {
+		return nil, err
 	}
+	fill := (blockSize - prefix%blockSize) % blockSize
+	sacrificial := (prefix + fill) / blockSize
+
+	known := repeatA(blockSize)
+	wanted := append(repeatA(blockSize-len(injection)), injection...)
+	delta, err := Xor(known, wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz") {
+		input := repeatA(fill)
+		input = append(input, bytes.Repeat([]byte{f}, blockSize)...)
+		input = append(input, known...)
+
+		c, err := oracle(input)
+		if err != nil {
+			return nil, err
+		}
+
+		block := c[sacrificial*blockSize : (sacrificial+1)*blockSize]
+		flipped, err := Xor(block, delta)
+		if err != nil {
+			return nil, err
+		}
+		copy(block, flipped)
+
+		if accept == nil || accept(c) {
+			return c, nil
+		}
+	}
+
+	return nil, errors.New("no forged cookie was accepted")
 }
@@ -3,7 +3,7 @@ package matasano
 import (
 	"bytes"
 	"encoding/base64"
-	"net/url"
+	"errors"
 	"strings"
 	"testing"
 	"unicode/utf8"
@@ -26,7 +26,11 @@ func TestProblem9(t *testing.T) {
 	want := []byte("YELLOW SUBMARINE\x04\x04\x04\x04")
 	size := 20
 
-	out := PadPKCS7(in, size)
+	out, err := PadPKCS7(in, size)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
 	l, err := PadLenPKCS7(in, size)
 	if err != nil && err.Error() != "block not aligned" {
 		t.Log(err)
@@ -66,6 +70,15 @@ func TestAESECB(t *testing.T) {
 		t.Logf("got: %s; want: %s", plain[:len(test)], test)
 		t.FailNow()
 	}
+
+	if _, err := AESDecryptECB(enc, key[:8]); !errors.Is(err, ErrKeySize) {
+		t.Logf("got: %v, want: %v", err, ErrKeySize)
+		t.FailNow()
+	}
+	if _, err := AESEncryptECB(test, key[:8]); !errors.Is(err, ErrKeySize) {
+		t.Logf("got: %v, want: %v", err, ErrKeySize)
+		t.FailNow()
+	}
 }
 
 func TestAESCBC(t *testing.T) {
@@ -93,6 +106,13 @@ func TestAESCBC(t *testing.T) {
 		t.Logf("got: %s; want: %s", plain[:len(test)], test)
 		t.FailNow()
 	}
+
+	for _, c := range [][]byte{nil, enc[:8], enc[:len(enc)-1]} {
+		if _, err := AESDecryptCBC(c, key, iv); err != ErrNotAligned {
+			t.Logf("%d bytes: got: %v, want: %v", len(c), err, ErrNotAligned)
+			t.FailNow()
+		}
+	}
 }
 
 func TestProblem10(t *testing.T) {
@@ -137,13 +157,19 @@ func TestProblem11(t *testing.T) {
 	in := "abcdefghijklmnopabcdefghijklmnopabcdefghijklmnopabcdefghijklmnop"
 	size := 16
 
-	enc, err, want := AESEncryptionOracle([]byte(in))
+	enc, want, err := AESEncryptionOracle([]byte(in))
 	if err != nil {
 		t.Log(err)
 		t.FailNow()
 	}
 
-	got, err := AESDetectionOracle(enc, len(PadPKCS7([]byte(in), size)))
+	padded, err := PadPKCS7([]byte(in), size)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	got, err := AESDetectionOracle(enc, len(padded))
 	if err != nil {
 		t.Log(err)
 		t.FailNow()
@@ -160,12 +186,65 @@ func TestProblem11(t *testing.T) {
 func TestProblem12(t *testing.T) {
 	secret := "Um9sbGluJyBpbiBteSA1LjAKV2l0aCBteSByYWctdG9wIGRvd24gc28gbXkgaGFpciBjYW4gYmxvdwpUaGUgZ2lybGllcyBvbiBzdGFuZGJ5IHdhdmluZyBqdXN0IHRvIHNheSBoaQpEaWQgeW91IHN0b3A/IE5vLCBJIGp1c3QgZHJvdmUgYnkK"
 	key := []byte("92ab18a6e64b824cc256c12c91087bdd")
-	_, err := ECBByteDecryption([]byte(secret), key)
+	got, err := ECBByteDecryption([]byte(secret), key)
 	if err != nil {
 		t.Log(err)
 		t.FailNow()
 	}
-	// t.Logf("got: %s", got)
+
+	want, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !bytes.Equal(got, want) {
+		t.Logf("got: %q, want: %q", got, want)
+		t.FailNow()
+	}
+	t.Logf("got: %s", got)
+}
+
+func TestECBOracle(t *testing.T) {
+	secret := []byte("attack at dawn")
+	key := []byte("YELLOW SUBMARINE")
+	oracle := NewECBSuffixOracle(secret, key)
+
+	size, err := BlockSize(oracle)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if size != 16 {
+		t.Logf("got: %d, want: 16", size)
+		t.FailNow()
+	}
+
+	if ecb, err := IsECB(oracle, size); err != nil || !ecb {
+		t.Logf("got: %v, %v, want ECB", ecb, err)
+		t.FailNow()
+	}
+
+	n, err := ECBSuffixLength(oracle, size)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if n != len(secret) {
+		t.Logf("got: %d, want: %d", n, len(secret))
+		t.FailNow()
+	}
+
+	cbc := func(input []byte) ([]byte, error) {
+		return AESEncryptCBC(append(input, secret...), key, key)
+	}
+	if ecb, err := IsECB(cbc, size); err != nil || ecb {
+		t.Logf("got: %v, %v, want CBC", ecb, err)
+		t.FailNow()
+	}
+	if _, err := ECBByteAtATime(cbc); err == nil {
+		t.Log("broke a CBC oracle")
+		t.FailNow()
+	}
 }
 
 func TestParseParams(t *testing.T) {
@@ -178,15 +257,73 @@ func TestParseParams(t *testing.T) {
 	t.Logf("%v\n", got)
 }
 
+func TestCookieCodec(t *testing.T) {
+	cookie := Cookie{
+		{"comment1", "cooking MCs"},
+		{"userdata", ";admin=true&x=%"},
+		{"empty", ""},
+	}
+
+	tests := []struct {
+		codec CookieCodec
+		want  string
+	}{
+		{SemicolonCodec, "comment1=cooking%20MCs;userdata=%3Badmin%3Dtrue%26x%3D%25;empty="},
+		{AmpersandCodec, "comment1=cooking%20MCs&userdata=%3Badmin%3Dtrue%26x%3D%25&empty="},
+	}
+	for _, test := range tests {
+		got := test.codec.Encode(cookie)
+		if got != test.want {
+			t.Logf("got: %s, want: %s", got, test.want)
+			t.FailNow()
+		}
+
+		decoded, err := test.codec.Decode(got)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if len(decoded) != len(cookie) {
+			t.Logf("got: %v, want: %v", decoded, cookie)
+			t.FailNow()
+		}
+		for i := range cookie {
+			if decoded[i] != cookie[i] {
+				t.Logf("got: %v, want: %v", decoded[i], cookie[i])
+				t.FailNow()
+			}
+		}
+		if decoded.Get("userdata") != ";admin=true&x=%" || decoded.Get("admin") != "" {
+			t.Logf("got: %v", decoded)
+			t.FailNow()
+		}
+	}
+
+	bad := []string{
+		"a=1;b",
+		"a=1;=2",
+		"a=1=2",
+		"a=1&b=2",
+		"a=%2",
+		"a=%zz",
+	}
+	for _, b := range bad {
+		if got, err := SemicolonCodec.Decode(b); err == nil {
+			t.Logf("%q decoded to %v", b, got)
+			t.FailNow()
+		}
+	}
+}
+
 func TestProfileFor(t *testing.T) {
 	test := "foo@bar.com"
-	want := "email=foo@bar.com&role=user&uid="
+	want := "email=foo@bar.com&uid=10&role=user"
 	got, err := ProfileFor(test)
 	if err != nil {
 		t.Log(err)
 		t.FailNow()
 	}
-	if !strings.Contains(got, want) {
+	if got != want {
 		t.Logf("want: %+v\ngot: %+v\n", want, got)
 		t.FailNow()
 	}
@@ -204,7 +341,7 @@ func TestProblem13(t *testing.T) {
 	}
 
 	t.Logf(string(p))
-	v, err := url.ParseQuery(string(p))
+	v, err := AmpersandCodec.Decode(string(p))
 	if err != nil {
 		t.FailNow()
 	}
@@ -217,6 +354,84 @@ func TestProblem13(t *testing.T) {
 	}
 }
 
+func TestForgeECBProfile(t *testing.T) {
+	// Profiles laid out differently, the field before or after the email
+	layouts := []Cookie{
+		{{"email", ""}, {"uid", "10"}, {"role", "user"}},
+		{{"uid", "10"}, {"email", ""}, {"role", "user"}, {"comment", "none"}},
+		{{"lang", "en-US"}, {"role", "user"}, {"email", ""}, {"uid", "10"}},
+	}
+	newOracle := func(layout Cookie, key []byte) ProfileOracle {
+		return func(email string) ([]byte, error) {
+			email = strings.Replace(email, "&", "", -1)
+			email = strings.Replace(email, "=", "", -1)
+			profile := append(Cookie{}, layout...)
+			for i := range profile {
+				if profile[i].Key == "email" {
+					profile[i].Value = email
+				}
+			}
+			return EncryptCookie(AmpersandCodec.Encode(profile), key)
+		}
+	}
+
+	for _, keySize := range []int{16, 32} {
+		key, err := AESGenerateKey(keySize)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+
+		for _, layout := range layouts {
+			forged, err := ForgeECBProfile(newOracle(layout, key), layout, "role", "admin")
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+
+			plain, err := DecryptCookie(forged, key)
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			cookie, err := AmpersandCodec.Decode(string(plain))
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			if cookie.Get("role") != "admin" {
+				t.Logf("got: %s, want: role=admin", plain)
+				t.FailNow()
+			}
+		}
+	}
+
+	key := []byte("YELLOW SUBMARINE")
+	bad := []struct {
+		layout       Cookie
+		field, value string
+	}{
+		// uid= does not end a block
+		{layouts[1], "uid", "0"},
+		// Escaped by the encoding
+		{layouts[0], "role", "a b"},
+		{layouts[0], "role", "admin&uid=0"},
+		{layouts[0], "admin", "true"},
+	}
+	for _, b := range bad {
+		if _, err := ForgeECBProfile(newOracle(b.layout, key), b.layout, b.field, b.value); err == nil {
+			t.Logf("forged %s=%q", b.field, b.value)
+			t.FailNow()
+		}
+	}
+
+	// The oracle does not hand out the profile the layout says
+	if _, err := ForgeECBProfile(newOracle(layouts[0], key), layouts[1], "role", "admin"); err == nil {
+		t.Log("forged with the wrong layout")
+		t.FailNow()
+	}
+}
+
 func TestProblem14(t *testing.T) {
 	secret := "Um9sbGluJyBpbiBteSA1LjAKV2l0aCBteSByYWctdG9wIGRvd24gc28gbXkgaGFpciBjYW4gYmxvdwpUaGUgZ2lybGllcyBvbiBzdGFuZGJ5IHdhdmluZyBqdXN0IHRvIHNheSBoaQpEaWQgeW91IHN0b3A/IE5vLCBJIGp1c3QgZHJvdmUgYnkK"
 	key := []byte("92ab18a6e64b824cc256c12c91087bdd")
@@ -225,38 +440,197 @@ func TestProblem14(t *testing.T) {
 		t.Log(err)
 		t.FailNow()
 	}
+
+	want, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !bytes.Equal(got, want) {
+		t.Logf("got: %q, want: %q", got, want)
+		t.FailNow()
+	}
 	t.Logf("got: %s", got)
 }
 
+func TestECBPrefixOracle(t *testing.T) {
+	secret := []byte("attack at dawn, bring the noise")
+	key := []byte("YELLOW SUBMARINE")
+
+	// A prefix repeating blocks on its own must not fool the sentinels
+	prefixes := [][]byte{
+		nil,
+		[]byte("A"),
+		bytes.Repeat([]byte("A"), 37),
+		bytes.Repeat([]byte("x"), 48),
+	}
+	for _, prefix := range prefixes {
+		got, err := ECBByteAtATimePrefix(NewECBPrefixOracle(prefix, secret, key))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if !bytes.Equal(got, secret) {
+			t.Logf("prefix: %d, got: %q, want: %q", len(prefix), got, secret)
+			t.FailNow()
+		}
+	}
+
+	// No prefix at all for a max of zero or less
+	for _, max := range []int{48, 0, -1} {
+		got, err := ECBByteAtATimePrefix(NewECBRandomPrefixOracle(secret, key, max))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if !bytes.Equal(got, secret) {
+			t.Logf("max: %d, got: %q, want: %q", max, got, secret)
+			t.FailNow()
+		}
+	}
+}
+
 func TestProblem15(t *testing.T) {
-	// test1 := []byte("ICE ICE BABY\x04\x04\x04\x04")
+	test1 := []byte("ICE ICE BABY\x04\x04\x04\x04")
 	test2 := []byte("ICE ICE BABY\x05\x05\x05\x05")
 	test3 := []byte("ICE ICE BABY\x01\x02\x03\x04")
 
-	// got, err := UnpadPKCS7(test1)
-	// if bytes.Equal(got, []byte("ICE ICE BABY")) && err != nil {
-	// 	t.Logf("got: %s", got)
-	// 	t.FailNow()
-	// }
+	got, err := UnpadPKCS7(test1, 16)
+	if err != nil || !bytes.Equal(got, []byte("ICE ICE BABY")) {
+		t.Logf("got: %s, %v, want: ICE ICE BABY", got, err)
+		t.FailNow()
+	}
 
-	got, err := UnpadPKCS7(test2)
+	got, err = UnpadPKCS7(test2, 16)
 	if err == nil {
 		t.Logf("got: %s, want: %s", got, test2)
 		t.FailNow()
 	}
 
-	got, err = UnpadPKCS7(test3)
+	got, err = UnpadPKCS7(test3, 16)
 	if err == nil {
 		t.Logf("got: %s, want: %s", got, test3)
 		t.FailNow()
 	}
 }
 
-func TestProblem16(t *testing.T) {
-	keySize := 16
-	success := false
+func TestPadPKCS7(t *testing.T) {
+	// Aligned input gets a whole block, even if it looks padded
+	in := []byte("YELLOW SUBMARIN\x01")
+	got, err := PadPKCS7(in[:16:16], 8)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	want := append([]byte("YELLOW SUBMARIN\x01"), bytes.Repeat([]byte{8}, 8)...)
+	if !bytes.Equal(got, want) {
+		t.Logf("got: %v, want: %v", got, want)
+		t.FailNow()
+	}
 
-	input := make([]byte, len([]byte("&admin=true")))
+	// The padding length must fit in a byte
+	for _, size := range []int{-1, 0, 256} {
+		if _, err := PadPKCS7(in, size); err != ErrBlockSize {
+			t.Logf("%d: got: %v, want: %v", size, err, ErrBlockSize)
+			t.FailNow()
+		}
+	}
+
+	bad := []struct {
+		in   []byte
+		size int
+		err  error
+	}{
+		{[]byte("YELLOW SUBMARINE\x00"), 17, ErrBadPadding},
+		{[]byte("YELLOW SUBMARINE\x11"), 17, ErrBadPadding},
+		{[]byte("YELLOW SUBMARINE\x02"), 17, ErrBadPadding},
+		{[]byte("YELLOW SUBMARINE\x01"), 16, ErrNotAligned},
+		{nil, 16, ErrBadPadding},
+		{[]byte("YELLOW SUBMARINE"), 0, ErrBlockSize},
+	}
+	for _, b := range bad {
+		if _, err := PadLenPKCS7(b.in, b.size); err != b.err {
+			t.Logf("%q: got: %v, want: %v", b.in, err, b.err)
+			t.FailNow()
+		}
+		if _, err := PadLenPKCS7ConstantTime(b.in, b.size); err != b.err {
+			t.Logf("%q: got: %v, want: %v", b.in, err, b.err)
+			t.FailNow()
+		}
+	}
+
+	for n := 0; n <= 32; n++ {
+		padded, err := PadPKCS7(bytes.Repeat([]byte("x"), n), 16)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		l, err := PadLenPKCS7ConstantTime(padded, 16)
+		if err != nil || len(padded)-l != n {
+			t.Logf("%d: got: %d, %v", n, l, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestPadding(t *testing.T) {
+	paddings := []Padding{
+		PKCS7Padding{16},
+		ANSIX923Padding{16},
+		ISO7816Padding{16},
+		ISO10126Padding{16},
+		ZeroPadding{16},
+		PKCS7Padding{8},
+		ISO7816Padding{1},
+	}
+	for _, p := range paddings {
+		for n := 0; n <= 33; n++ {
+			in := bytes.Repeat([]byte("x"), n)
+			padded, err := p.Pad(in)
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			got, err := p.Unpad(padded)
+			if err != nil {
+				t.Logf("%T: %d: %v", p, n, err)
+				t.FailNow()
+			}
+			if !bytes.Equal(got, in) {
+				t.Logf("%T: got: %v, want: %v", p, got, in)
+				t.FailNow()
+			}
+		}
+	}
+
+	bad := []struct {
+		p  Padding
+		in string
+	}{
+		{PKCS7Padding{4}, "abc\x02"},
+		{ANSIX923Padding{4}, "ab\x01\x02"},
+		{ANSIX923Padding{4}, "abc\x00"},
+		{ISO7816Padding{4}, "ab\x00\x00"},
+		{ISO7816Padding{4}, "a\x80\x01\x00"},
+		{ISO7816Padding{4}, "abc\x00\x00\x00\x00\x00"},
+		{ISO10126Padding{4}, "abc\x05"},
+		{ZeroPadding{4}, "abc"},
+	}
+	for _, b := range bad {
+		if got, err := b.p.Unpad([]byte(b.in)); err != ErrBadPadding && err != ErrNotAligned {
+			t.Logf("%T %q: got: %q, %v", b.p, b.in, got, err)
+			t.FailNow()
+		}
+	}
+
+	if _, err := (PKCS7Padding{256}).Pad(nil); err != ErrBlockSize {
+		t.Logf("got: %v, want: %v", err, ErrBlockSize)
+		t.FailNow()
+	}
+}
+
+func TestBitflipCookieCBC(t *testing.T) {
+	keySize := 16
 
 	key, err := AESGenerateKey(keySize)
 	if err != nil {
@@ -270,17 +644,93 @@ func TestProblem16(t *testing.T) {
 		t.FailNow()
 	}
 
-	cipher, err := GenerateCookieCBC(input, key, iv)
+	admin, err := BitflipCookieCBC(key, iv)
+	if err != nil || !admin {
+		t.Logf("got: %v, %v, want admin", admin, err)
+		t.FailNow()
+	}
+}
+
+func TestPrefixLength(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	iv := make([]byte, 16)
+
+	got, err := PrefixLength(NewCBCCookieOracle(key, iv), 16)
 	if err != nil {
 		t.Log(err)
 		t.FailNow()
 	}
+	if want := len("comment1=cooking%20MCs;userdata="); got != want {
+		t.Logf("got: %d, want: %d", got, want)
+		t.FailNow()
+	}
 
-	for i := 0; success == false; i++ {
-		success, err = BitflipCookieCBC(cipher, key, iv)
-		if err != nil && err.Error() != "not admin" {
-			t.Log(err)
-			t.FailNow()
+	for _, n := range []int{0, 1, 15, 16, 17, 40} {
+		prefix := bytes.Repeat([]byte("x"), n)
+		oracle := func(input []byte) ([]byte, error) {
+			return AESEncryptCBC(append(append([]byte{}, prefix...), input...), key, iv)
+		}
+		for _, o := range []ECBOracle{oracle, NewECBPrefixOracle(prefix, []byte("secret"), key)} {
+			got, err := PrefixLength(o, 16)
+			if err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			if got != n {
+				t.Logf("got: %d, want: %d", got, n)
+				t.FailNow()
+			}
 		}
 	}
 }
+
+func TestProblem16(t *testing.T) {
+	key, err := AESGenerateKey(16)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	iv, err := AESGenerateKey(16)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	accept := func(cipher []byte) bool {
+		admin, _ := AdminCookieCBC(cipher, key, iv)
+		return admin
+	}
+
+	forged, err := CBCBitflip(NewCBCCookieOracle(key, iv), []byte(";admin=true"), accept)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	admin, err := AdminCookieCBC(forged, key, iv)
+	if err != nil || !admin {
+		t.Logf("got: %v, %v, want admin", admin, err)
+		t.FailNow()
+	}
+
+	// Metacharacters the oracle would escape, and a field that is not admin
+	forged, err = CBCBitflip(NewCBCCookieOracle(key, iv), []byte(";role=x%3By;a="), nil)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	p, err := AESDecryptCBC(forged, key, iv)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !bytes.Contains(p, []byte("AA;role=x%3By;a=;comment2=")) {
+		t.Logf("got: %q", p)
+		t.FailNow()
+	}
+
+	if _, err := CBCBitflip(NewCBCCookieOracle(key, iv), bytes.Repeat([]byte("x"), 17), nil); err == nil {
+		t.Log("injected more than a block")
+		t.FailNow()
+	}
+}
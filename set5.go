@@ -0,0 +1,231 @@
+package matasano
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"math/big"
+)
+
+var (
+	bigZero = big.NewInt(0)
+	bigOne  = big.NewInt(1)
+	bigTwo  = big.NewInt(2)
+)
+
+// bigFromString parses a constant, decimal or hex with a 0x prefix.
+// Constants are known to be valid: a typo panics at init
+func bigFromString(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		panic("invalid constant " + s)
+	}
+	return n
+}
+
+// DHNISTPrime is the 1536 bit MODP prime suggested in Challenge 33,
+// to be used with generator 2
+var DHNISTPrime = bigFromString(
+	"0xffffffffffffffffc90fdaa22168c234c4c6628b80dc1cd129024" +
+		"e088a67cc74020bbea63b139b22514a08798e3404ddef9519b3cd" +
+		"3a431b302b0a6df25f14374fe1356d6d51c245e485b576625e7ec" +
+		"6f44c42e9a637ed6b0bff5cb6f406b7edee386bfb5a899fa5ae9f" +
+		"24117c4b1fe649286651ece45b3dc2007cb8a163bf0598da48361" +
+		"c55d39a69163fa8fd24cf5f83655d23dca3ad961c62f356208552" +
+		"bb9ed529077096966d670c354e4abc9804f1746c08ca237327fff" +
+		"fffffffffffff")
+
+// DHGenerateKey returns a private key in [1, q) and the matching
+// public key g^priv mod p. q is the order of g, when it's not
+// known p will do
+func DHGenerateKey(p, g, q *big.Int) (*big.Int, *big.Int, error) {
+
+	if q.Cmp(bigTwo) < 0 {
+		return nil, nil, errors.New("group order too small")
+	}
+
+	// Pick a number in [0, q-1) and shift it by one
+	priv, err := crand.Int(crand.Reader, new(big.Int).Sub(q, bigOne))
+	if err != nil {
+		return nil, nil, err
+	}
+	priv.Add(priv, bigOne)
+
+	return priv, new(big.Int).Exp(g, priv, p), nil
+}
+
+// DHSharedSecret computes the secret both parties agree on,
+// pub^priv mod p
+func DHSharedSecret(pub, priv, p *big.Int) *big.Int {
+	return new(big.Int).Exp(pub, priv, p)
+}
+
+// CRT solves the system x = residues[i] mod moduli[i] with the
+// Chinese Remainder Theorem. The moduli must be pairwise coprime.
+// It returns x along with the product of the moduli.
+//
+// This function is used in Challenge 40 and Challenge 57
+func CRT(residues, moduli []*big.Int) (*big.Int, *big.Int, error) {
+
+	if len(residues) != len(moduli) || len(moduli) == 0 {
+		return nil, nil, errors.New("need as many residues as moduli")
+	}
+
+	n := big.NewInt(1)
+	for _, m := range moduli {
+		n.Mul(n, m)
+	}
+
+	x := new(big.Int)
+	for i, m := range moduli {
+		// ms = n / m_i, the product of all other moduli
+		ms := new(big.Int).Div(n, m)
+
+		inv := new(big.Int).ModInverse(ms, m)
+		if inv == nil {
+			return nil, nil, errors.New("moduli are not pairwise coprime")
+		}
+
+		t := new(big.Int).Mul(residues[i], ms)
+		t.Mul(t, inv)
+		x.Add(x, t)
+	}
+
+	return x.Mod(x, n), n, nil
+}
+
+// RSAKey holds an RSA modulus along with both exponents.
+// D is nil for public keys
+type RSAKey struct {
+	N, E, D *big.Int
+}
+
+// Public strips the private exponent from the key
+func (k *RSAKey) Public() *RSAKey {
+	return &RSAKey{N: k.N, E: k.E}
+}
+
+// RSAGenerateKey creates a key whose modulus is bits long. As Challenge
+// 39 suggests, e is 3: primes are drawn until e is invertible
+func RSAGenerateKey(bits int) (*RSAKey, error) {
+
+	e := big.NewInt(3)
+
+	for {
+		p, err := crand.Prime(crand.Reader, bits/2)
+		if err != nil {
+			return nil, err
+		}
+		q, err := crand.Prime(crand.Reader, bits-bits/2)
+		if err != nil {
+			return nil, err
+		}
+		if p.Cmp(q) == 0 {
+			continue
+		}
+
+		n := new(big.Int).Mul(p, q)
+		if n.BitLen() != bits {
+			continue
+		}
+
+		et := new(big.Int).Mul(new(big.Int).Sub(p, bigOne), new(big.Int).Sub(q, bigOne))
+		d := new(big.Int).ModInverse(e, et)
+		if d == nil {
+			continue
+		}
+
+		return &RSAKey{N: n, E: e, D: d}, nil
+	}
+}
+
+// RSAEncrypt computes m^e mod n
+func RSAEncrypt(m *big.Int, key *RSAKey) (*big.Int, error) {
+
+	if m.Sign() < 0 || m.Cmp(key.N) >= 0 {
+		return nil, errors.New("message out of range")
+	}
+
+	return new(big.Int).Exp(m, key.E, key.N), nil
+}
+
+// RSADecrypt computes c^d mod n
+func RSADecrypt(c *big.Int, key *RSAKey) (*big.Int, error) {
+
+	if key.D == nil {
+		return nil, errors.New("missing private exponent")
+	}
+	if c.Sign() < 0 || c.Cmp(key.N) >= 0 {
+		return nil, errors.New("cipher out of range")
+	}
+
+	return new(big.Int).Exp(c, key.D, key.N), nil
+}
+
+// IntRoot returns the integer k-th root of x, rounded down,
+// using Newton's method
+func IntRoot(x *big.Int, k int) (*big.Int, error) {
+
+	if x.Sign() < 0 || k < 1 {
+		return nil, errors.New("root of a negative number or of order < 1")
+	}
+	if x.Sign() == 0 || k == 1 {
+		return new(big.Int).Set(x), nil
+	}
+
+	bk := big.NewInt(int64(k))
+	bk1 := big.NewInt(int64(k - 1))
+
+	// Start above the root: 2^ceil(bits/k)
+	r := new(big.Int).Lsh(bigOne, uint(x.BitLen()+k-1)/uint(k))
+	for {
+		// r' = ((k-1) r + x / r^(k-1)) / k
+		t := new(big.Int).Exp(r, bk1, nil)
+		t.Div(x, t)
+		t.Add(t, new(big.Int).Mul(bk1, r))
+		t.Div(t, bk)
+		if t.Cmp(r) >= 0 {
+			return r, nil
+		}
+		r = t
+	}
+}
+
+// Challenge 40
+// RSABroadcastAttack recovers a message encrypted under e different
+// keys sharing the public exponent e, 3 in the challenge. m^e is smaller
+// than the product of the moduli: the CRT gives it back whole, and its
+// integer e-th root is m
+func RSABroadcastAttack(ciphers []*big.Int, keys []*RSAKey) (*big.Int, error) {
+
+	if len(ciphers) != len(keys) || len(keys) == 0 {
+		return nil, errors.New("need as many ciphers as keys")
+	}
+
+	e := keys[0].E
+	if !e.IsInt64() || int64(len(keys)) < e.Int64() {
+		return nil, errors.New("need at least e ciphers")
+	}
+
+	moduli := make([]*big.Int, len(keys))
+	for i, k := range keys {
+		if k.E.Cmp(e) != 0 {
+			return nil, errors.New("keys use different exponents")
+		}
+		moduli[i] = k.N
+	}
+
+	c, _, err := CRT(ciphers, moduli)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := IntRoot(c, int(e.Int64()))
+	if err != nil {
+		return nil, err
+	}
+	if new(big.Int).Exp(m, e, nil).Cmp(c) != 0 {
+		return nil, errors.New("not the same message under every key")
+	}
+
+	return m, nil
+}
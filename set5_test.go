@@ -0,0 +1,132 @@
+package matasano
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestDH(t *testing.T) {
+	p := DHNISTPrime
+	g := big.NewInt(2)
+
+	a, A, err := DHGenerateKey(p, g, p)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	b, B, err := DHGenerateKey(p, g, p)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if s1, s2 := DHSharedSecret(B, a, p), DHSharedSecret(A, b, p); s1.Cmp(s2) != 0 {
+		t.Logf("s1: %v, s2: %v", s1, s2)
+		t.FailNow()
+	}
+}
+
+func TestCRT(t *testing.T) {
+	residues := []*big.Int{big.NewInt(2), big.NewInt(3), big.NewInt(2)}
+	moduli := []*big.Int{big.NewInt(3), big.NewInt(5), big.NewInt(7)}
+
+	x, n, err := CRT(residues, moduli)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if x.Int64() != 23 || n.Int64() != 105 {
+		t.Logf("got: %v mod %v, want: 23 mod 105", x, n)
+		t.FailNow()
+	}
+
+	_, _, err = CRT(residues, []*big.Int{big.NewInt(3), big.NewInt(6), big.NewInt(7)})
+	if err == nil {
+		t.Log("non coprime moduli were accepted")
+		t.FailNow()
+	}
+}
+
+func TestRSA(t *testing.T) {
+	key, err := RSAGenerateKey(1024)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	m := big.NewInt(42)
+	c, err := RSAEncrypt(m, key.Public())
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	got, err := RSADecrypt(c, key)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got.Cmp(m) != 0 {
+		t.Logf("got: %v, want: %v", got, m)
+		t.FailNow()
+	}
+}
+
+func TestIntRoot(t *testing.T) {
+	for _, x := range []int64{0, 1, 7, 8, 9, 26, 27, 28, 1000000} {
+		got, err := IntRoot(big.NewInt(x), 3)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		r := got.Int64()
+		if r*r*r > x || (r+1)*(r+1)*(r+1) <= x {
+			t.Logf("got: %d, the cube root of %d", r, x)
+			t.FailNow()
+		}
+	}
+
+	if _, err := IntRoot(big.NewInt(-8), 3); err == nil {
+		t.Log("root of a negative number")
+		t.FailNow()
+	}
+}
+
+func TestProblem40(t *testing.T) {
+	m := new(big.Int).SetBytes([]byte("Buy a big bag of bananas"))
+
+	keys := make([]*RSAKey, 3)
+	ciphers := make([]*big.Int, 3)
+	for i := range keys {
+		key, err := RSAGenerateKey(512)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		keys[i] = key.Public()
+
+		ciphers[i], err = RSAEncrypt(m, keys[i])
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+	}
+
+	got, err := RSABroadcastAttack(ciphers, keys)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if got.Cmp(m) != 0 {
+		t.Logf("got: %s, want: %s", got.Bytes(), m.Bytes())
+		t.FailNow()
+	}
+
+	if _, err := RSABroadcastAttack(ciphers[:2], keys[:2]); err == nil {
+		t.Log("broke e = 3 with two ciphers")
+		t.FailNow()
+	}
+}
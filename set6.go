@@ -0,0 +1,72 @@
+package matasano
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// sha256DigestInfo is the ASN.1 DigestInfo prefix that
+// PKCS#1 v1.5 puts in front of a SHA-256 hash
+var sha256DigestInfo = []byte{
+	0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01,
+	0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20,
+}
+
+// PadPKCS1Signature encodes the SHA-256 hash of msg as
+// 00 01 FF ... FF 00 DigestInfo hash, k bytes long
+func PadPKCS1Signature(msg []byte, k int) ([]byte, error) {
+
+	h := sha256.Sum256(msg)
+	t := append(append([]byte{}, sha256DigestInfo...), h[:]...)
+
+	// At least 8 bytes of FF are required
+	if k < len(t)+11 {
+		return nil, errors.New("modulus too short")
+	}
+
+	em := make([]byte, k)
+	em[1] = 0x01
+	for i := 2; i < k-len(t)-1; i++ {
+		em[i] = 0xff
+	}
+	copy(em[k-len(t):], t)
+
+	return em, nil
+}
+
+// RSASign signs msg with PKCS#1 v1.5 and SHA-256
+func RSASign(msg []byte, key *RSAKey) (*big.Int, error) {
+
+	em, err := PadPKCS1Signature(msg, (key.N.BitLen()+7)/8)
+	if err != nil {
+		return nil, err
+	}
+
+	return RSADecrypt(new(big.Int).SetBytes(em), key)
+}
+
+// RSAVerify checks sig is a PKCS#1 v1.5 signature of msg. Unlike
+// the verifier broken in Challenge 42, the whole block is compared
+func RSAVerify(msg []byte, sig *big.Int, key *RSAKey) bool {
+
+	k := (key.N.BitLen() + 7) / 8
+
+	m, err := RSAEncrypt(sig, key)
+	if err != nil {
+		return false
+	}
+
+	em, err := PadPKCS1Signature(msg, k)
+	if err != nil {
+		return false
+	}
+
+	// Left pad the result to the length of the modulus
+	got := make([]byte, k)
+	b := m.Bytes()
+	copy(got[k-len(b):], b)
+
+	return bytes.Equal(got, em)
+}
@@ -0,0 +1,37 @@
+package matasano
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestRSASign(t *testing.T) {
+	msg := []byte("hi mom")
+
+	key, err := RSAGenerateKey(1024)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	sig, err := RSASign(msg, key)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if !RSAVerify(msg, sig, key.Public()) {
+		t.Log("valid signature rejected")
+		t.FailNow()
+	}
+
+	if RSAVerify([]byte("hi dad"), sig, key.Public()) {
+		t.Log("signature of another message accepted")
+		t.FailNow()
+	}
+
+	if RSAVerify(msg, new(big.Int).Add(sig, bigOne), key.Public()) {
+		t.Log("forged signature accepted")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,240 @@
+package matasano
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// RC4 holds the state of the RC4 stream cipher
+type RC4 struct {
+	s    [256]byte
+	i, j byte
+}
+
+// NewRC4 runs the key scheduling algorithm and returns
+// a cipher ready to produce the key stream. Keys must be
+// between 1 and 256 bytes long
+func NewRC4(key []byte) (*RC4, error) {
+
+	if len(key) < 1 || len(key) > 256 {
+		return nil, fmt.Errorf("%w, use 1 to 256 bytes", ErrKeySize)
+	}
+
+	r := &RC4{}
+	for i := 0; i < 256; i++ {
+		r.s[i] = byte(i)
+	}
+
+	var j byte
+	for i, k := 0, 0; i < 256; i, k = i+1, k+1 {
+		if k == len(key) {
+			k = 0
+		}
+		j += r.s[i] + key[k]
+		r.s[i], r.s[j] = r.s[j], r.s[i]
+	}
+
+	return r, nil
+}
+
+// XORKeyStream xors src with the key stream and stores the result in dst.
+// dst and src may overlap entirely
+func (r *RC4) XORKeyStream(dst, src []byte) {
+
+	i, j := r.i, r.j
+	for k := 0; k < len(src); k++ {
+		i++
+		j += r.s[i]
+		r.s[i], r.s[j] = r.s[j], r.s[i]
+		dst[k] = src[k] ^ r.s[r.s[i]+r.s[j]]
+	}
+	r.i, r.j = i, j
+}
+
+// RC4Encrypt encrypts (or decrypts, the operation is symmetric)
+// in with the given key
+func RC4Encrypt(in, key []byte) ([]byte, error) {
+
+	r, err := NewRC4(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(in))
+	r.XORKeyStream(out, in)
+
+	return out, nil
+}
+
+// RC4Oracle encrypts an attacker controlled request
+// along with some secret the attacker is after
+type RC4Oracle func(request []byte) ([]byte, error)
+
+// NewRC4CookieOracle returns an oracle that computes
+// RC4(request || cookie, key), using a fresh random 128 bit key
+// for every single request.
+//
+// This function is used in Challenge 56
+func NewRC4CookieOracle(cookie []byte) RC4Oracle {
+	return func(request []byte) ([]byte, error) {
+
+		key := make([]byte, 16)
+		if _, err := crand.Read(key); err != nil {
+			return nil, err
+		}
+
+		plain := make([]byte, 0, len(request)+len(cookie))
+		plain = append(plain, request...)
+		plain = append(plain, cookie...)
+
+		return RC4Encrypt(plain, key)
+	}
+}
+
+// rc4Biases lists the (zero based) positions of the key stream along
+// with the byte they are biased towards: Z16 leans to 240 and Z32 to 224
+var rc4Biases = []struct {
+	pos  int
+	bias byte
+}{
+	{15, 240},
+	{31, 224},
+}
+
+// Challenge 56
+// RC4BiasAttack recovers the first n bytes of the secret appended
+// by oracle. Padding the request with 15-k bytes moves the k-th byte
+// of the secret under position 16 of the key stream (and byte k+16
+// under position 32). Over enough ciphertexts the most frequent value
+// at those positions is the plaintext xored with the biased byte.
+//
+// Every padding length costs trials oracle calls, which are spread
+// over one goroutine per CPU. The biases are only about 2% above
+// uniform: below 2^24 trials per byte the guesses get unreliable, so
+// asking for a shorter prefix is the way to make the attack cheaper
+func RC4BiasAttack(oracle RC4Oracle, n, trials int) ([]byte, error) {
+
+	blockSize := 16
+
+	if trials < 1 {
+		return nil, errors.New("trials must be positive")
+	}
+
+	c, err := oracle(nil)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > len(c) {
+		return nil, errors.New("cannot recover more bytes than the secret holds")
+	}
+	if n > 2*blockSize {
+		return nil, errors.New("only the first 32 bytes sit under a biased position")
+	}
+
+	secret := make([]byte, n)
+
+	// One request per padding length recovers two bytes at once
+	pads := n
+	if pads > blockSize {
+		pads = blockSize
+	}
+
+	for k := 0; k < pads; k++ {
+		request := make([]byte, blockSize-1-k)
+		for i := 0; i < len(request); i++ {
+			request[i] = byte('A')
+		}
+
+		counts, err := rc4CountBiases(oracle, request, trials)
+		if err != nil {
+			return nil, err
+		}
+
+		for b, z := range rc4Biases {
+			i := z.pos - len(request)
+			if i >= n {
+				continue
+			}
+			secret[i] = mostFrequent(&counts[b]) ^ z.bias
+		}
+	}
+
+	return secret, nil
+}
+
+// rc4CountBiases queries oracle trials times with the same request and
+// tallies how often every byte value shows up at the biased positions
+func rc4CountBiases(oracle RC4Oracle, request []byte, trials int) ([][256]int, error) {
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+
+	counts := make([][256]int, len(rc4Biases))
+
+	workers := runtime.NumCPU()
+	if workers > trials {
+		workers = trials
+	}
+
+	for w := 0; w < workers; w++ {
+		// Hand the remainder to the first workers
+		share := trials / workers
+		if w < trials%workers {
+			share++
+		}
+
+		wg.Add(1)
+		go func(share int) {
+			defer wg.Done()
+
+			local := make([][256]int, len(rc4Biases))
+
+			for t := 0; t < share; t++ {
+				c, err := oracle(request)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+				for b, z := range rc4Biases {
+					if z.pos < len(c) {
+						local[b][c[z.pos]]++
+					}
+				}
+			}
+
+			mu.Lock()
+			for b := range local {
+				for v := 0; v < 256; v++ {
+					counts[b][v] += local[b][v]
+				}
+			}
+			mu.Unlock()
+		}(share)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return counts, nil
+}
+
+// mostFrequent returns the byte with the highest count
+func mostFrequent(counts *[256]int) byte {
+	var best byte
+	for b := 1; b < 256; b++ {
+		if counts[b] > counts[best] {
+			best = byte(b)
+		}
+	}
+	return best
+}
@@ -0,0 +1,72 @@
+package matasano
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"testing"
+)
+
+// Some of the attacks need hours worth of oracle queries. By default
+// the tests only run a reduced version of them, pass -full to run
+// them the way the challenges describe
+var full = flag.Bool("full", false, "run the attacks with the full number of trials")
+
+func TestRC4(t *testing.T) {
+	want, err := hex.DecodeString("bbf316e8d940af0ad3")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	got, err := RC4Encrypt([]byte("Plaintext"), []byte("Key"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Logf("got: %x, want: %x", got, want)
+		t.FailNow()
+	}
+
+	plain, err := RC4Encrypt(got, []byte("Key"))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if string(plain) != "Plaintext" {
+		t.Logf("got: %s, want: Plaintext", plain)
+		t.FailNow()
+	}
+}
+
+func TestProblem56(t *testing.T) {
+	cookie, err := base64.StdEncoding.DecodeString("QkUgU1VSRSBUTyBEUklOSyBZT1VSIE9WQUxUSU5F")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// Each padding length needs 2^24 ciphertexts to be reliable,
+	// so short of a full run only the first byte is recovered
+	n := 1
+	if *full {
+		n = len(cookie)
+	}
+
+	got, err := RC4BiasAttack(NewRC4CookieOracle(cookie), n, 1<<24)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if !bytes.Equal(got, cookie[:n]) {
+		t.Logf("got: %q, want: %q", got, cookie[:n])
+		t.FailNow()
+	}
+
+	t.Logf("cookie: %s", got)
+}
@@ -0,0 +1,3488 @@
+package matasano
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/bits"
+)
+
+// DH group used in Challenge 57: g generates a subgroup of
+// order q, leaving plenty of small subgroups in (p-1)/q
+var (
+	Ch57P = bigFromString("7199773997391911030609999317773941274322764333428698921736339643928346453700085358802973900485592910475480089726140708102474957429903531369589969318716771")
+	Ch57G = bigFromString("4565356397095740655436854503483826832136106141639563487732438195343690437606117828318042418238184896212352329118608100083187535033402010599512641674644143")
+	Ch57Q = bigFromString("236234353446506858198510045061214171961")
+)
+
+// DHMac authenticates msg with HMAC-SHA256, keyed
+// with a DH shared secret
+func DHMac(secret *big.Int, msg []byte) []byte {
+	mac := hmac.New(sha256.New, secret.Bytes())
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// DHBobOracle plays Bob: it takes the attacker's public key
+// and answers with a message and its MAC
+type DHBobOracle func(pub *big.Int) ([]byte, []byte, error)
+
+// NewDHBobOracle returns a Bob that derives the shared secret
+// from his private key priv, without checking the public key
+// he is given belongs to the right group.
+//
+// This function is used in Challenge 57
+func NewDHBobOracle(p, priv *big.Int) DHBobOracle {
+	return func(pub *big.Int) ([]byte, []byte, error) {
+
+		if pub.Sign() <= 0 || pub.Cmp(p) >= 0 {
+			return nil, nil, errors.New("public key out of range")
+		}
+
+		msg := []byte("crazy flamboyant for the rap enjoyment")
+		return msg, DHMac(DHSharedSecret(pub, priv, p), msg), nil
+	}
+}
+
+// SmallFactors returns the distinct prime factors of n
+// that are smaller than bound, found by trial division
+func SmallFactors(n *big.Int, bound int64) []*big.Int {
+
+	var factors []*big.Int
+
+	m := new(big.Int).Set(n)
+	r := new(big.Int)
+	for i := int64(2); i < bound && m.Cmp(bigOne) > 0; i++ {
+		f := big.NewInt(i)
+		if r.Mod(m, f).Sign() != 0 {
+			continue
+		}
+		factors = append(factors, f)
+		for r.Mod(m, f).Sign() == 0 {
+			m.Div(m, f)
+		}
+	}
+
+	return factors
+}
+
+// subgroupElement returns an element of order r in Z_p*,
+// r must divide p-1
+func subgroupElement(p, r *big.Int) (*big.Int, error) {
+
+	e := new(big.Int).Sub(p, bigOne)
+	e.Div(e, r)
+
+	for {
+		// Pick anything in [1, p) and raise it to (p-1)/r
+		h, err := crand.Int(crand.Reader, new(big.Int).Sub(p, bigOne))
+		if err != nil {
+			return nil, err
+		}
+		h.Add(h, bigOne)
+
+		if h.Exp(h, e, p).Cmp(bigOne) != 0 {
+			return h, nil
+		}
+	}
+}
+
+// DHSubgroupResidues recovers Bob's private key modulo each of
+// factors. Every factor must divide p-1: the public key sent is
+// an element of that small order, which confines the shared secret
+// to a handful of values the MAC can be checked against
+func DHSubgroupResidues(oracle DHBobOracle, p *big.Int, factors []*big.Int) ([]*big.Int, error) {
+
+	residues := make([]*big.Int, 0, len(factors))
+
+	for _, r := range factors {
+		h, err := subgroupElement(p, r)
+		if err != nil {
+			return nil, err
+		}
+
+		msg, mac, err := oracle(h)
+		if err != nil {
+			return nil, err
+		}
+
+		// Walk h^0, h^1, ... until the MAC matches
+		var found *big.Int
+		k := big.NewInt(1)
+		for b := int64(0); b < r.Int64(); b++ {
+			if hmac.Equal(DHMac(k, msg), mac) {
+				found = big.NewInt(b)
+				break
+			}
+			k.Mul(k, h)
+			k.Mod(k, p)
+		}
+
+		if found == nil {
+			return nil, errors.New("no residue matches the MAC")
+		}
+		residues = append(residues, found)
+	}
+
+	return residues, nil
+}
+
+// Challenge 57
+// DHSubgroupConfinementAttack recovers Bob's private key, an exponent
+// of g in the subgroup of order q, by collecting its residues modulo
+// the small factors of (p-1)/q until their product exceeds q and
+// gluing them together with the CRT
+func DHSubgroupConfinementAttack(oracle DHBobOracle, p, q *big.Int) (*big.Int, error) {
+
+	j := new(big.Int).Sub(p, bigOne)
+	j.Div(j, q)
+
+	// Since q itself is prime, the factors of j shared with q
+	// are dropped to keep the moduli coprime
+	var factors []*big.Int
+	prod := big.NewInt(1)
+	for _, r := range SmallFactors(j, 1<<16) {
+		if new(big.Int).Mod(q, r).Sign() == 0 {
+			continue
+		}
+		factors = append(factors, r)
+		prod.Mul(prod, r)
+		if prod.Cmp(q) > 0 {
+			break
+		}
+	}
+
+	if prod.Cmp(q) <= 0 {
+		return nil, errors.New("not enough small subgroups to cover q")
+	}
+
+	residues, err := DHSubgroupResidues(oracle, p, factors)
+	if err != nil {
+		return nil, err
+	}
+
+	x, _, err := CRT(residues, factors)
+	if err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+// DH group used in Challenge 58: (p-1)/q doesn't have enough
+// small factors anymore to recover the whole key from them
+var (
+	Ch58P = bigFromString("11470374874925275658116663507232161402086650258453896274534991676898999262641581519101074740642369848233294239851519212341844337347119899874391456329785623")
+	Ch58G = bigFromString("622952335333961296978159266084741085889881358738459939978290179936063635566740258555167783009058567397963466103140082647486611657350811560630587013183357")
+	Ch58Q = bigFromString("335062023296420808191071248367701059461")
+)
+
+// KangarooJump is the pseudorandom function deciding how far a
+// kangaroo jumps from y. It must only depend on y
+type KangarooJump func(y *big.Int) uint64
+
+// KangarooConfig tunes Pollard's kangaroo. The zero value picks
+// everything from the size of the interval
+type KangarooConfig struct {
+	// Jump defaults to 2^(y mod K)
+	Jump KangarooJump
+	K    uint
+
+	// N is how many jumps the tame kangaroo makes, it defaults
+	// to four times the mean jump
+	N uint64
+
+	// MaxSteps caps the jumps of the wild kangaroo, 0 lets it run
+	// until it overtakes the tame one
+	MaxSteps uint64
+}
+
+// kangarooMaxK caps the exponent picked from the interval: the tame
+// kangaroo already makes about 2^29 jumps with it
+const kangarooMaxK = 32
+
+// kangarooPowJump is the jump function suggested by the challenge
+func kangarooPowJump(k uint) KangarooJump {
+	return func(y *big.Int) uint64 {
+		var low uint64
+		if bits := y.Bits(); len(bits) > 0 {
+			low = uint64(bits[0])
+		}
+		return 1 << (low % uint64(k))
+	}
+}
+
+// kangarooGroup is what the kangaroos need to know about the
+// cyclic group they jump around in
+type kangarooGroup interface {
+	// exp returns the generator raised to n
+	exp(n *big.Int) interface{}
+	// mul combines two elements
+	mul(x, y interface{}) interface{}
+	equal(x, y interface{}) bool
+	// value is what the jump function gets to look at
+	value(x interface{}) *big.Int
+}
+
+// modpGroup is the subgroup of Z_p* generated by g
+type modpGroup struct {
+	p, g *big.Int
+}
+
+func (m modpGroup) exp(n *big.Int) interface{} {
+	return new(big.Int).Exp(m.g, n, m.p)
+}
+
+func (m modpGroup) mul(x, y interface{}) interface{} {
+	r := new(big.Int).Mul(x.(*big.Int), y.(*big.Int))
+	return r.Mod(r, m.p)
+}
+
+func (m modpGroup) equal(x, y interface{}) bool {
+	return x.(*big.Int).Cmp(y.(*big.Int)) == 0
+}
+
+func (m modpGroup) value(x interface{}) *big.Int {
+	return x.(*big.Int)
+}
+
+// Challenge 58
+// Kangaroo computes the discrete log of y in base g modulo p,
+// knowing it lies in [a, b], with Pollard's lambda algorithm.
+// A tame kangaroo starts from g^b and leaves a trap where it stops,
+// a wild one starts from y: once their paths meet, the wild one falls
+// in the trap and the distance it travelled gives the log away
+func Kangaroo(p, g, y, a, b *big.Int, cfg *KangarooConfig) (*big.Int, error) {
+	return kangaroo(modpGroup{p, g}, y, a, b, cfg)
+}
+
+func kangaroo(grp kangarooGroup, y interface{}, a, b *big.Int, cfg *KangarooConfig) (*big.Int, error) {
+
+	if a.Cmp(b) > 0 {
+		return nil, errors.New("empty interval")
+	}
+
+	var c KangarooConfig
+	if cfg != nil {
+		c = *cfg
+	}
+
+	width := new(big.Int).Sub(b, a)
+
+	if c.Jump == nil {
+		// Pick k so that the mean jump (2^k-1)/k is about sqrt(b-a)/2
+		half := new(big.Int).Sqrt(width)
+		half.Rsh(half, 1)
+		if c.K == 0 {
+			for c.K = 1; ; c.K++ {
+				mean := new(big.Int).SetUint64((1<<c.K - 1) / uint64(c.K))
+				if mean.Cmp(half) >= 0 {
+					break
+				}
+				// Past the cap, the wild kangaroo would run about
+				// forever: only go on if it is told when to stop
+				if c.K == kangarooMaxK {
+					if c.MaxSteps == 0 {
+						return nil, errors.New("interval too wide, set K or MaxSteps")
+					}
+					break
+				}
+			}
+		}
+		if c.K > 63 {
+			return nil, errors.New("jump exponent too large")
+		}
+		c.Jump = kangarooPowJump(c.K)
+		if c.N == 0 {
+			c.N = 4 * ((1<<c.K - 1) / uint64(c.K))
+		}
+	}
+	if c.N == 0 {
+		return nil, errors.New("a custom jump function needs N")
+	}
+
+	// g^jump only takes a few values, no need to compute them twice
+	powers := make(map[uint64]interface{})
+	step := func(y interface{}) (interface{}, uint64) {
+		f := c.Jump(grp.value(y))
+		if _, ok := powers[f]; !ok {
+			powers[f] = grp.exp(new(big.Int).SetUint64(f))
+		}
+		return grp.mul(y, powers[f]), f
+	}
+
+	// Tame kangaroo, travelling from b
+	var f uint64
+	xT := new(big.Int)
+	yT := grp.exp(b)
+	for i := uint64(0); i < c.N; i++ {
+		yT, f = step(yT)
+		xT.Add(xT, new(big.Int).SetUint64(f))
+	}
+
+	// Wild kangaroo, travelling from the unknown x. If it gets past
+	// the trap without falling in it, x is not in the interval
+	limit := new(big.Int).Add(width, xT)
+	xW := new(big.Int)
+	yW := y
+	for i := uint64(0); xW.Cmp(limit) <= 0; i++ {
+		if c.MaxSteps != 0 && i >= c.MaxSteps {
+			return nil, errors.New("wild kangaroo ran out of steps")
+		}
+
+		if grp.equal(yW, yT) {
+			// b + xT = x + xW
+			x := new(big.Int).Add(b, xT)
+			return x.Sub(x, xW), nil
+		}
+
+		yW, f = step(yW)
+		xW.Add(xW, new(big.Int).SetUint64(f))
+	}
+
+	return nil, errors.New("logarithm not found in the interval")
+}
+
+// DHKangarooAttack recovers Bob's private key when the small subgroups
+// only give it away modulo r: with x = n + m*r, the residue n comes from
+// the subgroup confinement attack and m is caught by the kangaroo in
+// [0, (q-1)/r], since y * g^-n = (g^r)^m
+func DHKangarooAttack(oracle DHBobOracle, p, g, q, y *big.Int, cfg *KangarooConfig) (*big.Int, error) {
+
+	j := new(big.Int).Sub(p, bigOne)
+	j.Div(j, q)
+
+	var factors []*big.Int
+	for _, f := range SmallFactors(j, 1<<16) {
+		if new(big.Int).Mod(q, f).Sign() != 0 {
+			factors = append(factors, f)
+		}
+	}
+
+	if len(factors) == 0 {
+		return nil, errors.New("no small subgroups to confine the key to")
+	}
+
+	residues, err := DHSubgroupResidues(oracle, p, factors)
+	if err != nil {
+		return nil, err
+	}
+
+	n, r, err := CRT(residues, factors)
+	if err != nil {
+		return nil, err
+	}
+
+	// The residues alone already pin the key down
+	if r.Cmp(q) > 0 {
+		return n, nil
+	}
+
+	gr := new(big.Int).Exp(g, r, p)
+	yr := new(big.Int).Exp(g, n, p)
+	yr.ModInverse(yr, p)
+	yr.Mul(yr, y)
+	yr.Mod(yr, p)
+
+	bound := new(big.Int).Sub(q, bigOne)
+	bound.Div(bound, r)
+
+	m, err := Kangaroo(p, gr, yr, bigZero, bound, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Mul(m, r).Add(m, n), nil
+}
+
+// ECPoint is an affine point on an elliptic curve. The point
+// at infinity, the group identity, has nil coordinates
+type ECPoint struct {
+	X, Y *big.Int
+}
+
+// IsInfinity tells whether pt is the point at infinity
+func (pt *ECPoint) IsInfinity() bool {
+	return pt.X == nil
+}
+
+// Equal compares two affine points
+func (pt *ECPoint) Equal(o *ECPoint) bool {
+	if pt.IsInfinity() || o.IsInfinity() {
+		return pt.IsInfinity() == o.IsInfinity()
+	}
+	return pt.X.Cmp(o.X) == 0 && pt.Y.Cmp(o.Y) == 0
+}
+
+// JacobianPoint represents the affine point (X/Z^2, Y/Z^3), sparing
+// a modular inversion on every operation. Z = 0 is the point at infinity
+type JacobianPoint struct {
+	X, Y, Z *big.Int
+}
+
+// WeierstrassCurve is the curve y^2 = x^3 + ax + b over GF(p)
+type WeierstrassCurve struct {
+	A, B, P *big.Int
+}
+
+// Challenge 59 curve, its base point G of prime order Q
+// and the number of points on the curve, 8 * Q
+var (
+	Ch59Curve = &WeierstrassCurve{
+		A: big.NewInt(-95051),
+		B: big.NewInt(11279326),
+		P: bigFromString("233970423115425145524320034830162017933"),
+	}
+	Ch59G = &ECPoint{
+		X: big.NewInt(182),
+		Y: bigFromString("85518893674295321206118380980485522083"),
+	}
+	Ch59Q     = bigFromString("29246302889428143187362802287225875743")
+	Ch59Order = bigFromString("233970423115425145498902418297807005944")
+)
+
+// mod reduces n into [0, p) in place
+func (c *WeierstrassCurve) mod(n *big.Int) *big.Int {
+	return n.Mod(n, c.P)
+}
+
+// IsOnCurve checks pt satisfies the curve equation
+func (c *WeierstrassCurve) IsOnCurve(pt *ECPoint) bool {
+	if pt.IsInfinity() {
+		return true
+	}
+	return c.mod(new(big.Int).Mul(pt.Y, pt.Y)).Cmp(c.rhs(pt.X)) == 0
+}
+
+// rhs evaluates x^3 + ax + b
+func (c *WeierstrassCurve) rhs(x *big.Int) *big.Int {
+	r := new(big.Int).Mul(x, x)
+	r.Add(r, c.A)
+	r.Mul(r, x)
+	r.Add(r, c.B)
+	return c.mod(r)
+}
+
+// Neg returns -pt
+func (c *WeierstrassCurve) Neg(pt *ECPoint) *ECPoint {
+	if pt.IsInfinity() {
+		return &ECPoint{}
+	}
+	return &ECPoint{new(big.Int).Set(pt.X), c.mod(new(big.Int).Neg(pt.Y))}
+}
+
+// Add adds two affine points with the chord and tangent rule
+func (c *WeierstrassCurve) Add(p1, p2 *ECPoint) *ECPoint {
+
+	if p1.IsInfinity() {
+		return p2
+	}
+	if p2.IsInfinity() {
+		return p1
+	}
+
+	// The slope of the line through p1 and p2, or of the
+	// tangent when they are the same point. A vertical
+	// line means p1 = -p2
+	m := new(big.Int)
+	if p1.X.Cmp(p2.X) == 0 {
+		if p1.Y.Cmp(p2.Y) != 0 || p1.Y.Sign() == 0 {
+			return &ECPoint{}
+		}
+		m.Mul(p1.X, p1.X)
+		m.Mul(m, big.NewInt(3))
+		m.Add(m, c.A)
+		m.Mul(m, new(big.Int).ModInverse(new(big.Int).Lsh(p1.Y, 1), c.P))
+	} else {
+		m.Sub(p2.Y, p1.Y)
+		m.Mul(m, new(big.Int).ModInverse(c.mod(new(big.Int).Sub(p2.X, p1.X)), c.P))
+	}
+	c.mod(m)
+
+	x := new(big.Int).Mul(m, m)
+	x.Sub(x, p1.X)
+	x.Sub(x, p2.X)
+	c.mod(x)
+
+	y := new(big.Int).Sub(p1.X, x)
+	y.Mul(y, m)
+	y.Sub(y, p1.Y)
+	c.mod(y)
+
+	return &ECPoint{x, y}
+}
+
+// ScalarMultAffine computes k*pt by double and add over affine points
+func (c *WeierstrassCurve) ScalarMultAffine(pt *ECPoint, k *big.Int) *ECPoint {
+
+	r := &ECPoint{}
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		r = c.Add(r, r)
+		if k.Bit(i) == 1 {
+			r = c.Add(r, pt)
+		}
+	}
+	return r
+}
+
+// ToJacobian lifts an affine point to Jacobian coordinates
+func (c *WeierstrassCurve) ToJacobian(pt *ECPoint) *JacobianPoint {
+	if pt.IsInfinity() {
+		return &JacobianPoint{big.NewInt(1), big.NewInt(1), big.NewInt(0)}
+	}
+	return &JacobianPoint{new(big.Int).Set(pt.X), new(big.Int).Set(pt.Y), big.NewInt(1)}
+}
+
+// ToAffine maps a Jacobian point back to (X/Z^2, Y/Z^3)
+func (c *WeierstrassCurve) ToAffine(pt *JacobianPoint) *ECPoint {
+
+	if pt.Z.Sign() == 0 {
+		return &ECPoint{}
+	}
+
+	zinv := new(big.Int).ModInverse(pt.Z, c.P)
+	zinv2 := c.mod(new(big.Int).Mul(zinv, zinv))
+
+	x := c.mod(new(big.Int).Mul(pt.X, zinv2))
+	y := new(big.Int).Mul(pt.Y, zinv2)
+	y.Mul(y, zinv)
+
+	return &ECPoint{x, c.mod(y)}
+}
+
+// JacobianDouble computes 2*pt
+func (c *WeierstrassCurve) JacobianDouble(pt *JacobianPoint) *JacobianPoint {
+
+	if pt.Z.Sign() == 0 || pt.Y.Sign() == 0 {
+		return &JacobianPoint{big.NewInt(1), big.NewInt(1), big.NewInt(0)}
+	}
+
+	// S = 4XY^2, M = 3X^2 + aZ^4
+	y2 := c.mod(new(big.Int).Mul(pt.Y, pt.Y))
+	s := new(big.Int).Mul(pt.X, y2)
+	c.mod(s.Lsh(s, 2))
+
+	z2 := c.mod(new(big.Int).Mul(pt.Z, pt.Z))
+	m := new(big.Int).Mul(z2, z2)
+	m.Mul(m, c.A)
+	x2 := new(big.Int).Mul(pt.X, pt.X)
+	m.Add(m, x2.Mul(x2, big.NewInt(3)))
+	c.mod(m)
+
+	// X' = M^2 - 2S
+	x := new(big.Int).Mul(m, m)
+	x.Sub(x, new(big.Int).Lsh(s, 1))
+	c.mod(x)
+
+	// Y' = M(S - X') - 8Y^4
+	y := new(big.Int).Sub(s, x)
+	y.Mul(y, m)
+	y4 := new(big.Int).Mul(y2, y2)
+	y.Sub(y, y4.Lsh(y4, 3))
+	c.mod(y)
+
+	// Z' = 2YZ
+	z := new(big.Int).Mul(pt.Y, pt.Z)
+	c.mod(z.Lsh(z, 1))
+
+	return &JacobianPoint{x, y, z}
+}
+
+// JacobianAdd computes p1 + p2
+func (c *WeierstrassCurve) JacobianAdd(p1, p2 *JacobianPoint) *JacobianPoint {
+
+	if p1.Z.Sign() == 0 {
+		return p2
+	}
+	if p2.Z.Sign() == 0 {
+		return p1
+	}
+
+	// Bring both points to the same denominator:
+	// U1 = X1Z2^2, U2 = X2Z1^2, S1 = Y1Z2^3, S2 = Y2Z1^3
+	z1z1 := c.mod(new(big.Int).Mul(p1.Z, p1.Z))
+	z2z2 := c.mod(new(big.Int).Mul(p2.Z, p2.Z))
+
+	u1 := c.mod(new(big.Int).Mul(p1.X, z2z2))
+	u2 := c.mod(new(big.Int).Mul(p2.X, z1z1))
+
+	s1 := new(big.Int).Mul(p1.Y, z2z2)
+	c.mod(s1.Mul(s1, p2.Z))
+	s2 := new(big.Int).Mul(p2.Y, z1z1)
+	c.mod(s2.Mul(s2, p1.Z))
+
+	if u1.Cmp(u2) == 0 {
+		if s1.Cmp(s2) != 0 {
+			return &JacobianPoint{big.NewInt(1), big.NewInt(1), big.NewInt(0)}
+		}
+		return c.JacobianDouble(p1)
+	}
+
+	// H = U2 - U1, R = S2 - S1
+	h := c.mod(new(big.Int).Sub(u2, u1))
+	r := c.mod(new(big.Int).Sub(s2, s1))
+
+	h2 := c.mod(new(big.Int).Mul(h, h))
+	h3 := c.mod(new(big.Int).Mul(h2, h))
+	u1h2 := c.mod(new(big.Int).Mul(u1, h2))
+
+	// X3 = R^2 - H^3 - 2U1H^2
+	x := new(big.Int).Mul(r, r)
+	x.Sub(x, h3)
+	x.Sub(x, new(big.Int).Lsh(u1h2, 1))
+	c.mod(x)
+
+	// Y3 = R(U1H^2 - X3) - S1H^3
+	y := new(big.Int).Sub(u1h2, x)
+	y.Mul(y, r)
+	y.Sub(y, h3.Mul(h3, s1))
+	c.mod(y)
+
+	// Z3 = HZ1Z2
+	z := new(big.Int).Mul(h, p1.Z)
+	c.mod(z.Mul(z, p2.Z))
+
+	return &JacobianPoint{x, y, z}
+}
+
+// ScalarMult computes k*pt, doing the work in Jacobian coordinates.
+// Note that b never shows up in the formulas
+func (c *WeierstrassCurve) ScalarMult(pt *ECPoint, k *big.Int) *ECPoint {
+
+	base := c.ToJacobian(pt)
+	r := c.ToJacobian(&ECPoint{})
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		r = c.JacobianDouble(r)
+		if k.Bit(i) == 1 {
+			r = c.JacobianAdd(r, base)
+		}
+	}
+	return c.ToAffine(r)
+}
+
+// RandomPoint picks a random point on the curve
+func (c *WeierstrassCurve) RandomPoint() (*ECPoint, error) {
+	for {
+		x, err := crand.Int(crand.Reader, c.P)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only half of the x coordinates have a matching y
+		if y := new(big.Int).ModSqrt(c.rhs(x), c.P); y != nil {
+			return &ECPoint{x, y}, nil
+		}
+	}
+}
+
+// PointOrder returns the order of pt, given a multiple m of it
+// along with all the prime factors of m
+func (c *WeierstrassCurve) PointOrder(pt *ECPoint, m *big.Int, factors []*big.Int) *big.Int {
+
+	order := new(big.Int).Set(m)
+	r := new(big.Int)
+	for _, f := range factors {
+		for r.Mod(order, f).Sign() == 0 {
+			d := new(big.Int).Div(order, f)
+			if !c.ScalarMult(pt, d).IsInfinity() {
+				break
+			}
+			order = d
+		}
+	}
+	return order
+}
+
+// ECDHGenerateKey returns a private key in [1, q) and the public
+// key priv*g, where g is a point of order q
+func ECDHGenerateKey(c *WeierstrassCurve, g *ECPoint, q *big.Int) (*big.Int, *ECPoint, error) {
+
+	priv, err := crand.Int(crand.Reader, new(big.Int).Sub(q, bigOne))
+	if err != nil {
+		return nil, nil, err
+	}
+	priv.Add(priv, bigOne)
+
+	return priv, c.ScalarMult(g, priv), nil
+}
+
+// ECMac authenticates msg with HMAC-SHA256, keyed with
+// both coordinates of an ECDH shared point
+func ECMac(secret *ECPoint, msg []byte) []byte {
+	var key []byte
+	if !secret.IsInfinity() {
+		key = append(secret.X.Bytes(), secret.Y.Bytes()...)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+// ECDHBobOracle plays Bob over an elliptic curve: it takes the
+// attacker's public point and answers with a message and its MAC
+type ECDHBobOracle func(pub *ECPoint) ([]byte, []byte, error)
+
+// NewECDHBobOracle returns a Bob that trusts any point he is
+// given to lie on his curve.
+//
+// This function is used in Challenge 59
+func NewECDHBobOracle(c *WeierstrassCurve, priv *big.Int) ECDHBobOracle {
+	return func(pub *ECPoint) ([]byte, []byte, error) {
+		msg := []byte("crazy flamboyant for the rap enjoyment")
+		return msg, ECMac(c.ScalarMult(pub, priv), msg), nil
+	}
+}
+
+// InvalidCurve is a curve sharing a and p with the target one,
+// but whose b gives it a smooth number of points
+type InvalidCurve struct {
+	B, Order *big.Int
+}
+
+// Ch59InvalidCurves are the curves suggested by the challenge
+var Ch59InvalidCurves = []InvalidCurve{
+	{big.NewInt(210), bigFromString("233970423115425145550826547352470124412")},
+	{big.NewInt(504), bigFromString("233970423115425145544350131142039591210")},
+	{big.NewInt(727), bigFromString("233970423115425145545378039958152057148")},
+}
+
+// Challenge 59
+// ECInvalidCurveAttack recovers Bob's private key modulo q. Since b
+// never takes part in the scalar multiplication, Bob happily multiplies
+// points of small order r taken from curves with another b, and the
+// MAC he sends back tells which of the r multiples he ended up with.
+// The residues are then glued together with the CRT
+func ECInvalidCurveAttack(oracle ECDHBobOracle, c *WeierstrassCurve, q *big.Int, curves []InvalidCurve) (*big.Int, error) {
+
+	var residues, moduli []*big.Int
+	prod := big.NewInt(1)
+
+	seen := make(map[int64]bool)
+
+	for _, ic := range curves {
+		bogus := &WeierstrassCurve{A: c.A, B: ic.B, P: c.P}
+
+		for _, r := range SmallFactors(ic.Order, 1<<16) {
+			if seen[r.Int64()] || prod.Cmp(q) > 0 {
+				continue
+			}
+
+			// Find a point of order r. The cofactor drops every power
+			// of r, since the r-part of the group need not be cyclic
+			cofactor := new(big.Int).Set(ic.Order)
+			for new(big.Int).Mod(cofactor, r).Sign() == 0 {
+				cofactor.Div(cofactor, r)
+			}
+			h := &ECPoint{}
+			for h.IsInfinity() {
+				pt, err := bogus.RandomPoint()
+				if err != nil {
+					return nil, err
+				}
+				h = bogus.ScalarMult(pt, cofactor)
+			}
+			for rh := bogus.ScalarMult(h, r); !rh.IsInfinity(); rh = bogus.ScalarMult(h, r) {
+				h = rh
+			}
+
+			msg, mac, err := oracle(h)
+			if err != nil {
+				return nil, err
+			}
+
+			// Walk 0h, 1h, ... until the MAC matches
+			var found *big.Int
+			k := &ECPoint{}
+			for b := int64(0); b < r.Int64(); b++ {
+				if hmac.Equal(ECMac(k, msg), mac) {
+					found = big.NewInt(b)
+					break
+				}
+				k = bogus.Add(k, h)
+			}
+
+			if found == nil {
+				return nil, errors.New("no residue matches the MAC")
+			}
+
+			seen[r.Int64()] = true
+			residues = append(residues, found)
+			moduli = append(moduli, r)
+			prod.Mul(prod, r)
+		}
+	}
+
+	if prod.Cmp(q) <= 0 {
+		return nil, errors.New("not enough small subgroups to cover q")
+	}
+
+	x, _, err := CRT(residues, moduli)
+	if err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+// ecGroup is the subgroup of an elliptic curve generated by g
+type ecGroup struct {
+	c *WeierstrassCurve
+	g *ECPoint
+}
+
+func (e ecGroup) exp(n *big.Int) interface{} {
+	return e.c.ScalarMult(e.g, n)
+}
+
+func (e ecGroup) mul(x, y interface{}) interface{} {
+	return e.c.Add(x.(*ECPoint), y.(*ECPoint))
+}
+
+func (e ecGroup) equal(x, y interface{}) bool {
+	return x.(*ECPoint).Equal(y.(*ECPoint))
+}
+
+func (e ecGroup) value(x interface{}) *big.Int {
+	if pt := x.(*ECPoint); !pt.IsInfinity() {
+		return pt.X
+	}
+	return bigZero
+}
+
+// ECKangaroo finds k in [a, b] such that y = k*g, the elliptic
+// curve flavour of Kangaroo
+func ECKangaroo(c *WeierstrassCurve, g, y *ECPoint, a, b *big.Int, cfg *KangarooConfig) (*big.Int, error) {
+	return kangaroo(ecGroup{c, g}, y, a, b, cfg)
+}
+
+// MontgomeryCurve is the curve Bv^2 = u^3 + Au^2 + u over GF(p)
+type MontgomeryCurve struct {
+	A, B, P *big.Int
+}
+
+// Challenge 60 curve: the Montgomery form of Ch59Curve, its
+// base point u = 4 maps to Ch59G (or its opposite)
+var (
+	Ch60Curve = &MontgomeryCurve{
+		A: big.NewInt(534),
+		B: big.NewInt(1),
+		P: Ch59Curve.P,
+	}
+	Ch60G = big.NewInt(4)
+)
+
+func (c *MontgomeryCurve) mod(n *big.Int) *big.Int {
+	return n.Mod(n, c.P)
+}
+
+// rhs evaluates u^3 + Au^2 + u
+func (c *MontgomeryCurve) rhs(u *big.Int) *big.Int {
+	r := new(big.Int).Add(u, c.A)
+	r.Mul(r, u)
+	r.Add(r, bigOne)
+	r.Mul(r, u)
+	return c.mod(r)
+}
+
+// OnTwist tells whether u belongs to a point of the quadratic twist
+// rather than of the curve: that happens when (u^3 + Au^2 + u)/B
+// has no square root
+func (c *MontgomeryCurve) OnTwist(u *big.Int) bool {
+	r := new(big.Int).Mul(c.rhs(u), new(big.Int).ModInverse(c.B, c.P))
+	return big.Jacobi(c.mod(r), c.P) == -1
+}
+
+// TwistOrder returns the number of points on the twist,
+// given the number of points n on the curve
+func (c *MontgomeryCurve) TwistOrder(n *big.Int) *big.Int {
+	t := new(big.Int).Lsh(c.P, 1)
+	t.Add(t, bigTwo)
+	return t.Sub(t, n)
+}
+
+// RandomTwistPoint picks the u coordinate of a random point on the twist
+func (c *MontgomeryCurve) RandomTwistPoint() (*big.Int, error) {
+	for {
+		u, err := crand.Int(crand.Reader, c.P)
+		if err != nil {
+			return nil, err
+		}
+		if c.OnTwist(u) {
+			return u, nil
+		}
+	}
+}
+
+// Ladder computes the u coordinate of k*(u, v) with the Montgomery
+// ladder, which never needs v. The point at infinity comes out as 0
+func (c *MontgomeryCurve) Ladder(u, k *big.Int) *big.Int {
+
+	u2, w2 := big.NewInt(1), big.NewInt(0)
+	u3, w3 := new(big.Int).Set(u), big.NewInt(1)
+
+	bits := c.P.BitLen()
+	if k.BitLen() > bits {
+		bits = k.BitLen()
+	}
+
+	for i := bits - 1; i >= 0; i-- {
+		if k.Bit(i) == 1 {
+			u2, u3 = u3, u2
+			w2, w3 = w3, w2
+		}
+
+		// u3, w3 = (u2*u3 - w2*w3)^2, u * (u2*w3 - w2*u3)^2
+		t1 := new(big.Int).Mul(u2, u3)
+		t1.Sub(t1, new(big.Int).Mul(w2, w3))
+		t1.Mul(t1, t1)
+
+		t2 := new(big.Int).Mul(u2, w3)
+		t2.Sub(t2, new(big.Int).Mul(w2, u3))
+		t2.Mul(t2, t2)
+		t2.Mul(t2, u)
+
+		// u2, w2 = (u2^2 - w2^2)^2, 4*u2*w2 * (u2^2 + A*u2*w2 + w2^2)
+		uu := new(big.Int).Mul(u2, u2)
+		ww := new(big.Int).Mul(w2, w2)
+		uw := new(big.Int).Mul(u2, w2)
+
+		t3 := new(big.Int).Sub(uu, ww)
+		t3.Mul(t3, t3)
+
+		t4 := new(big.Int).Mul(c.A, uw)
+		t4.Add(t4, uu)
+		t4.Add(t4, ww)
+		t4.Mul(t4, uw)
+		t4.Lsh(t4, 2)
+
+		u3, w3 = c.mod(t1), c.mod(t2)
+		u2, w2 = c.mod(t3), c.mod(t4)
+
+		if k.Bit(i) == 1 {
+			u2, u3 = u3, u2
+			w2, w3 = w3, w2
+		}
+	}
+
+	// w2^(p-2) is the inverse of w2, or 0 for the point at infinity
+	e := new(big.Int).Sub(c.P, bigTwo)
+	return c.mod(u2.Mul(u2, w2.Exp(w2, e, c.P)))
+}
+
+// ToWeierstrass returns the short Weierstrass form of the curve,
+// a = (3 - A^2) / 3B^2 and b = (2A^3 - 9A) / 27B^3
+func (c *MontgomeryCurve) ToWeierstrass() *WeierstrassCurve {
+
+	b2 := c.mod(new(big.Int).Mul(c.B, c.B))
+	b3 := c.mod(new(big.Int).Mul(b2, c.B))
+	a2 := c.mod(new(big.Int).Mul(c.A, c.A))
+
+	a := new(big.Int).Sub(big.NewInt(3), a2)
+	a.Mul(a, new(big.Int).ModInverse(b2.Mul(b2, big.NewInt(3)), c.P))
+
+	b := new(big.Int).Mul(a2, c.A)
+	b.Lsh(b, 1)
+	b.Sub(b, new(big.Int).Mul(c.A, big.NewInt(9)))
+	b.Mul(b, new(big.Int).ModInverse(b3.Mul(b3, big.NewInt(27)), c.P))
+
+	return &WeierstrassCurve{A: c.mod(a), B: c.mod(b), P: c.P}
+}
+
+// PointToWeierstrass maps (u, v) to (u/B + A/3B, v/B)
+func (c *MontgomeryCurve) PointToWeierstrass(u, v *big.Int) *ECPoint {
+
+	binv := new(big.Int).ModInverse(c.B, c.P)
+
+	x := new(big.Int).Mul(c.A, new(big.Int).ModInverse(big.NewInt(3), c.P))
+	x.Add(x, u)
+	x.Mul(x, binv)
+
+	y := new(big.Int).Mul(v, binv)
+
+	return &ECPoint{c.mod(x), c.mod(y)}
+}
+
+// PointFromWeierstrass maps (x, y) back to (Bx - A/3, By)
+func (c *MontgomeryCurve) PointFromWeierstrass(pt *ECPoint) (*big.Int, *big.Int) {
+
+	u := new(big.Int).Mul(c.B, pt.X)
+	u.Sub(u, new(big.Int).Mul(c.A, new(big.Int).ModInverse(big.NewInt(3), c.P)))
+
+	v := new(big.Int).Mul(c.B, pt.Y)
+
+	return c.mod(u), c.mod(v)
+}
+
+// LiftX returns one of the two points with the given u coordinate,
+// or nil when u lies on the twist
+func (c *MontgomeryCurve) LiftX(u *big.Int) *big.Int {
+	r := new(big.Int).Mul(c.rhs(u), new(big.Int).ModInverse(c.B, c.P))
+	return new(big.Int).ModSqrt(c.mod(r), c.P)
+}
+
+// MontgomeryBobOracle plays Bob on a Montgomery curve: he only
+// looks at the u coordinate of the attacker's public key
+type MontgomeryBobOracle func(u *big.Int) ([]byte, []byte, error)
+
+// NewMontgomeryBobOracle returns a Bob that runs the ladder on any
+// u he is given, whether it lies on the curve or on its twist.
+//
+// This function is used in Challenge 60
+func NewMontgomeryBobOracle(c *MontgomeryCurve, priv *big.Int) MontgomeryBobOracle {
+	return func(u *big.Int) ([]byte, []byte, error) {
+		msg := []byte("crazy flamboyant for the rap enjoyment")
+		return msg, DHMac(c.Ladder(u, priv), msg), nil
+	}
+}
+
+// twistPointOfOrder returns the u coordinate of a point of order m on
+// the twist. m must be a product of distinct primes dividing order,
+// the number of points on the twist
+func (c *MontgomeryCurve) twistPointOfOrder(m, order *big.Int, primes []*big.Int) (*big.Int, error) {
+
+	// Strip every power of the primes from the order, and
+	// remember the extra ones
+	cofactor := new(big.Int).Set(order)
+	extra := big.NewInt(1)
+	r := new(big.Int)
+	for _, f := range primes {
+		for r.Mod(cofactor, f).Sign() == 0 {
+			cofactor.Div(cofactor, f)
+			extra.Mul(extra, f)
+		}
+		extra.Div(extra, f)
+	}
+
+	for {
+		u, err := c.RandomTwistPoint()
+		if err != nil {
+			return nil, err
+		}
+
+		h := c.Ladder(c.Ladder(u, cofactor), extra)
+
+		// The order must be exactly m, no smaller divisor will do
+		ok := h.Sign() != 0
+		for _, f := range primes {
+			if ok && c.Ladder(h, new(big.Int).Div(m, f)).Sign() == 0 {
+				ok = false
+			}
+		}
+		if ok {
+			return h, nil
+		}
+	}
+}
+
+// twistResidue finds k in [0, r/2] such that u(k*h) produced the mac,
+// walking the multiples of h with the x-only differential addition
+// u(m+n) * u(m-n) * (u(m) - u(n))^2 = (u(m)*u(n) - 1)^2
+func (c *MontgomeryCurve) twistResidue(h, r *big.Int, msg, mac []byte) (*big.Int, error) {
+
+	if hmac.Equal(DHMac(bigZero, msg), mac) {
+		return big.NewInt(0), nil
+	}
+
+	prev, curr := bigZero, h
+	for k := int64(1); k <= r.Int64()/2; k++ {
+		if hmac.Equal(DHMac(curr, msg), mac) {
+			return big.NewInt(k), nil
+		}
+
+		var next *big.Int
+		if k == 1 {
+			// u(2h) = (u^2 - 1)^2 / 4u(u^2 + Au + 1)
+			next = c.Ladder(h, bigTwo)
+		} else {
+			num := new(big.Int).Mul(curr, h)
+			num.Sub(num, bigOne)
+			num.Mul(num, num)
+
+			den := new(big.Int).Sub(curr, h)
+			den.Mul(den, den)
+			den.Mul(den, prev)
+			c.mod(den)
+
+			next = c.mod(num.Mul(num, den.ModInverse(den, c.P)))
+		}
+		prev, curr = curr, next
+	}
+
+	return nil, errors.New("no residue matches the MAC")
+}
+
+// MontgomeryTwistResidues recovers Bob's private key x modulo R, the
+// product of the odd factors of the twist order below bound, though only
+// up to its sign: x = ±res mod R. Points on the twist, whose order is a
+// lot smoother than the curve's, leak the key modulo each small factor.
+// Querying a point whose order spans all the factors seen so far then
+// settles the sign against the previous residues
+func MontgomeryTwistResidues(oracle MontgomeryBobOracle, c *MontgomeryCurve, n *big.Int, bound int64) (*big.Int, *big.Int, error) {
+
+	order := c.TwistOrder(n)
+
+	// The points of order 2 don't tell the signs apart
+	var primes []*big.Int
+	for _, f := range SmallFactors(order, bound) {
+		if f.Cmp(bigTwo) != 0 {
+			primes = append(primes, f)
+		}
+	}
+	if len(primes) == 0 {
+		return nil, nil, errors.New("no small subgroups on the twist")
+	}
+
+	res, mod := big.NewInt(0), big.NewInt(1)
+	for i, r := range primes {
+		h, err := c.twistPointOfOrder(r, order, []*big.Int{r})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		msg, mac, err := oracle(h)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		k, err := c.twistResidue(h, r, msg, mac)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if i == 0 {
+			res, mod = k, new(big.Int).Set(r)
+			continue
+		}
+
+		// Either x = res + k or x = res - k, a point of order mod*r
+		// tells which one
+		m := new(big.Int).Mul(mod, r)
+		hm, err := c.twistPointOfOrder(m, order, primes[:i+1])
+		if err != nil {
+			return nil, nil, err
+		}
+
+		msg, mac, err = oracle(hm)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var found *big.Int
+		for _, kk := range []*big.Int{k, new(big.Int).Sub(r, k)} {
+			x, _, err := CRT([]*big.Int{res, kk}, []*big.Int{mod, r})
+			if err != nil {
+				return nil, nil, err
+			}
+			if hmac.Equal(DHMac(c.Ladder(hm, x), msg), mac) {
+				found = x
+				break
+			}
+		}
+		if found == nil {
+			return nil, nil, errors.New("no sign matches the MAC")
+		}
+
+		res, mod = found, m
+	}
+
+	return res, mod, nil
+}
+
+// Challenge 60
+// MontgomeryTwistAttack recovers a private key of Bob, who only checks
+// u coordinates. The twist gives the key away modulo R up to its sign,
+// what's left, x = ±res + m*R, is then tracked down with the kangaroo
+// on the Weierstrass form of the curve.
+//
+// Since u(x*G) = u(-x*G), the key returned may be x or q - x
+func MontgomeryTwistAttack(oracle MontgomeryBobOracle, c *MontgomeryCurve, g, pub, n, q *big.Int, bound int64, cfg *KangarooConfig) (*big.Int, error) {
+
+	res, mod, err := MontgomeryTwistResidues(oracle, c, n, bound)
+	if err != nil {
+		return nil, err
+	}
+
+	// The residues alone pin the key down: x < q < R, so x is either
+	// res or R - res, the one whose multiple of g is pub
+	if mod.Cmp(q) > 0 {
+		for _, x := range []*big.Int{res, new(big.Int).Sub(mod, res)} {
+			x.Mod(x, q)
+			if c.Ladder(g, x).Cmp(pub) == 0 {
+				return x, nil
+			}
+		}
+		return nil, errors.New("residues do not match the public key")
+	}
+
+	w := c.ToWeierstrass()
+	gv, yv := c.LiftX(g), c.LiftX(pub)
+	if gv == nil || yv == nil {
+		return nil, errors.New("points are not on the curve")
+	}
+	gw, yw := c.PointToWeierstrass(g, gv), c.PointToWeierstrass(pub, yv)
+	gr := w.ScalarMult(gw, mod)
+
+	// The lifted public key is either x*G or -x*G, and x = ±res + m*R
+	// with m in [0, B]. Whatever the signs, y - (±res)*G = m'*(R*G) for
+	// some m' in [-B, B], so two kangaroos cover all four cases
+	b := new(big.Int).Sub(q, bigOne)
+	b.Div(b, mod)
+	a := new(big.Int).Neg(b)
+
+	for _, s := range []*big.Int{res, new(big.Int).Neg(res)} {
+		y := w.Add(yw, w.Neg(w.ScalarMult(gw, new(big.Int).Mod(s, q))))
+
+		m, err := ECKangaroo(w, gr, y, a, b, cfg)
+		if err != nil {
+			continue
+		}
+
+		x := m.Mul(m, mod).Add(m, s)
+		return x.Mod(x, q), nil
+	}
+
+	return nil, errors.New("key not found in the interval")
+}
+
+// ECDSAPublicKey carries the whole domain along with the public
+// point: a curve, a generator G of prime order N and Q = d*G
+type ECDSAPublicKey struct {
+	Curve *WeierstrassCurve
+	G     *ECPoint
+	N     *big.Int
+	Q     *ECPoint
+}
+
+// ECDSAPrivateKey adds the secret scalar d to the public key
+type ECDSAPrivateKey struct {
+	ECDSAPublicKey
+	D *big.Int
+}
+
+// ECDSAGenerateKey picks d in [1, n) and computes Q = d*G
+func ECDSAGenerateKey(c *WeierstrassCurve, g *ECPoint, n *big.Int) (*ECDSAPrivateKey, error) {
+
+	d, q, err := ECDHGenerateKey(c, g, n)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ECDSAPrivateKey{ECDSAPublicKey{c, g, n, q}, d}, nil
+}
+
+// hashToInt truncates the SHA-256 hash of msg to the bit length of n
+func hashToInt(msg []byte, n *big.Int) *big.Int {
+
+	h := sha256.Sum256(msg)
+	e := new(big.Int).SetBytes(h[:])
+	if excess := len(h)*8 - n.BitLen(); excess > 0 {
+		e.Rsh(e, uint(excess))
+	}
+	return e
+}
+
+// ecdsaSignWithNonce signs the hash e with the given nonce k,
+// which must be in [1, n). It fails when r or s turn out to be 0
+func ecdsaSignWithNonce(priv *ECDSAPrivateKey, e, k *big.Int) (*big.Int, *big.Int, error) {
+
+	n := priv.N
+
+	// r = x(k*G) mod n
+	r := priv.Curve.ScalarMult(priv.G, k).X
+	if r == nil {
+		return nil, nil, errors.New("nonce is a multiple of the order")
+	}
+	r = new(big.Int).Mod(r, n)
+	if r.Sign() == 0 {
+		return nil, nil, errors.New("r is zero, pick another nonce")
+	}
+
+	// s = (e + d*r) / k mod n
+	s := new(big.Int).Mul(priv.D, r)
+	s.Add(s, e)
+	s.Mul(s, new(big.Int).ModInverse(k, n))
+	s.Mod(s, n)
+	if s.Sign() == 0 {
+		return nil, nil, errors.New("s is zero, pick another nonce")
+	}
+
+	return r, s, nil
+}
+
+// ECDSASign signs msg, hashed with SHA-256, using a fresh random nonce
+func ECDSASign(priv *ECDSAPrivateKey, msg []byte) (*big.Int, *big.Int, error) {
+
+	e := hashToInt(msg, priv.N)
+
+	for {
+		k, err := crand.Int(crand.Reader, new(big.Int).Sub(priv.N, bigOne))
+		if err != nil {
+			return nil, nil, err
+		}
+		k.Add(k, bigOne)
+
+		if r, s, err := ecdsaSignWithNonce(priv, e, k); err == nil {
+			return r, s, nil
+		}
+	}
+}
+
+// ECDSAVerify checks (r, s) is a signature of msg under pub
+func ECDSAVerify(pub *ECDSAPublicKey, msg []byte, r, s *big.Int) bool {
+
+	n := pub.N
+	if r.Sign() <= 0 || r.Cmp(n) >= 0 || s.Sign() <= 0 || s.Cmp(n) >= 0 {
+		return false
+	}
+
+	// R = u1*G + u2*Q, with u1 = e/s and u2 = r/s
+	w := new(big.Int).ModInverse(s, n)
+	if w == nil {
+		return false
+	}
+	u1 := new(big.Int).Mul(hashToInt(msg, n), w)
+	u1.Mod(u1, n)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, n)
+
+	c := pub.Curve
+	pt := c.Add(c.ScalarMult(pub.G, u1), c.ScalarMult(pub.Q, u2))
+	if pt.IsInfinity() {
+		return false
+	}
+
+	return new(big.Int).Mod(pt.X, n).Cmp(r) == 0
+}
+
+// Challenge 61
+// ECDSAKeySelection forges a brand new key under which an existing
+// signature (r, s) of msg still verifies. Verification only checks that
+// u1*G + u2*Q lands on R: picking any d', the generator
+// G' = R / (u1 + u2*d') and Q' = d'*G' satisfy u1*G' + u2*Q' = R
+func ECDSAKeySelection(pub *ECDSAPublicKey, msg []byte, r, s *big.Int) (*ECDSAPrivateKey, error) {
+
+	if !ECDSAVerify(pub, msg, r, s) {
+		return nil, errors.New("signature does not verify under the original key")
+	}
+
+	n, c := pub.N, pub.Curve
+
+	w := new(big.Int).ModInverse(s, n)
+	u1 := new(big.Int).Mul(hashToInt(msg, n), w)
+	u1.Mod(u1, n)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, n)
+
+	R := c.Add(c.ScalarMult(pub.G, u1), c.ScalarMult(pub.Q, u2))
+
+	for {
+		d, err := crand.Int(crand.Reader, new(big.Int).Sub(n, bigOne))
+		if err != nil {
+			return nil, err
+		}
+		d.Add(d, bigOne)
+
+		t := new(big.Int).Mul(u2, d)
+		t.Add(t, u1)
+		t.Mod(t, n)
+		if t.Sign() == 0 {
+			continue
+		}
+
+		g := c.ScalarMult(R, t.ModInverse(t, n))
+		q := c.ScalarMult(g, d)
+
+		return &ECDSAPrivateKey{ECDSAPublicKey{c, g, n, q}, d}, nil
+	}
+}
+
+// bsgs solves g^x = h mod p for x in [0, n) with
+// Shanks' baby step giant step
+func bsgs(g, h, p, n *big.Int) (*big.Int, error) {
+
+	m := new(big.Int).Sqrt(n)
+	m.Add(m, bigOne)
+
+	// Baby steps: g^j for j in [0, m)
+	table := make(map[string]int64)
+	e := big.NewInt(1)
+	for j := int64(0); j < m.Int64(); j++ {
+		if _, ok := table[string(e.Bytes())]; !ok {
+			table[string(e.Bytes())] = j
+		}
+		e.Mul(e, g)
+		e.Mod(e, p)
+	}
+
+	// Giant steps: h * g^-im
+	factor := new(big.Int).Exp(g, m, p)
+	factor.ModInverse(factor, p)
+	gamma := new(big.Int).Set(h)
+	for i := int64(0); i < m.Int64(); i++ {
+		if j, ok := table[string(gamma.Bytes())]; ok {
+			x := new(big.Int).Mul(big.NewInt(i), m)
+			return x.Add(x, big.NewInt(j)), nil
+		}
+		gamma.Mul(gamma, factor)
+		gamma.Mod(gamma, p)
+	}
+
+	return nil, errors.New("logarithm not found")
+}
+
+// PohligHellman solves g^x = h mod p, where order is the order of g
+// and primes are all of its prime factors. The log is computed in each
+// prime power subgroup, digit by digit, then put back together with
+// the CRT. It's only fast when the primes are small
+func PohligHellman(g, h, p, order *big.Int, primes []*big.Int) (*big.Int, error) {
+
+	var residues, moduli []*big.Int
+	rem := new(big.Int)
+
+	left := new(big.Int).Set(order)
+	for _, f := range primes {
+		// Find the power of f dividing the order
+		e := 0
+		fe := big.NewInt(1)
+		for rem.Mod(left, f).Sign() == 0 {
+			left.Div(left, f)
+			fe.Mul(fe, f)
+			e++
+		}
+		if e == 0 {
+			continue
+		}
+
+		// Move to the subgroup of order f^e
+		cof := new(big.Int).Div(order, fe)
+		gi := new(big.Int).Exp(g, cof, p)
+		hi := new(big.Int).Exp(h, cof, p)
+
+		// gamma has order f, each digit is a log in its subgroup
+		fe1 := new(big.Int).Div(fe, f)
+		gamma := new(big.Int).Exp(gi, fe1, p)
+
+		x := big.NewInt(0)
+		fk := big.NewInt(1)
+		for k := 0; k < e; k++ {
+			// hk = (gi^-x * hi)^(f^(e-1-k))
+			hk := new(big.Int).Exp(gi, x, p)
+			hk.ModInverse(hk, p)
+			hk.Mul(hk, hi)
+			hk.Mod(hk, p)
+			hk.Exp(hk, new(big.Int).Div(fe1, fk), p)
+
+			d, err := bsgs(gamma, hk, p, f)
+			if err != nil {
+				return nil, err
+			}
+
+			x.Add(x, d.Mul(d, fk))
+			fk.Mul(fk, f)
+		}
+
+		residues = append(residues, x)
+		moduli = append(moduli, fe)
+	}
+
+	if left.Cmp(bigOne) != 0 {
+		return nil, errors.New("primes do not cover the whole order")
+	}
+
+	x, _, err := CRT(residues, moduli)
+	if err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+// smoothPrime returns a prime p with at least bits bits such that
+// p-1 = 2 * r1 * r2 * ..., with distinct odd primes ri below 2^16
+// that are not in used. It returns the odd factors as well
+func smoothPrime(bits int, used map[int64]bool) (*big.Int, []*big.Int, error) {
+
+	var small []int64
+	for i := int64(3); i < 1<<16; i += 2 {
+		if !used[i] && big.NewInt(i).ProbablyPrime(0) {
+			small = append(small, i)
+		}
+	}
+
+	for {
+		p := big.NewInt(2)
+		var factors []*big.Int
+		picked := make(map[int64]bool)
+
+		for p.BitLen() < bits {
+			j, err := crand.Int(crand.Reader, big.NewInt(int64(len(small))))
+			if err != nil {
+				return nil, nil, err
+			}
+			r := small[j.Int64()]
+			if picked[r] {
+				continue
+			}
+			picked[r] = true
+			factors = append(factors, big.NewInt(r))
+			p.Mul(p, big.NewInt(r))
+		}
+
+		p.Add(p, bigOne)
+		if p.ProbablyPrime(20) {
+			return p, factors, nil
+		}
+	}
+}
+
+// isPrimitiveRoot tells whether g generates Z_p*, given
+// all the prime factors of p-1
+func isPrimitiveRoot(g, p *big.Int, primes []*big.Int) bool {
+
+	pm1 := new(big.Int).Sub(p, bigOne)
+	for _, f := range primes {
+		if new(big.Int).Exp(g, new(big.Int).Div(pm1, f), p).Cmp(bigOne) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RSAKeySelection forges an RSA key under which an existing PKCS#1 v1.5
+// signature of msg still verifies. The new modulus is the product of two
+// primes p and q with smooth p-1 and q-1, chosen so that sig generates
+// both multiplicative groups: the discrete log of the padded message in
+// base sig is then within reach of Pohlig-Hellman, modulo p-1 and q-1,
+// and the CRT turns the two logs into e'
+func RSAKeySelection(pub *RSAKey, msg []byte, sig *big.Int) (*RSAKey, error) {
+
+	if !RSAVerify(msg, sig, pub) {
+		return nil, errors.New("signature does not verify under the original key")
+	}
+
+	bits := pub.N.BitLen()/2 + 1
+
+	for {
+		// p-1 and q-1 only share the factor 2
+		used := make(map[int64]bool)
+
+		p, pf, err := smoothPrime(bits, used)
+		if err != nil {
+			return nil, err
+		}
+		pf = append(pf, bigTwo)
+		if !isPrimitiveRoot(sig, p, pf) {
+			continue
+		}
+		for _, f := range pf {
+			used[f.Int64()] = true
+		}
+
+		q, qf, err := smoothPrime(bits, used)
+		if err != nil {
+			return nil, err
+		}
+		qf = append(qf, bigTwo)
+		if !isPrimitiveRoot(sig, q, qf) {
+			continue
+		}
+
+		n := new(big.Int).Mul(p, q)
+		em, err := PadPKCS1Signature(msg, (n.BitLen()+7)/8)
+		if err != nil {
+			return nil, err
+		}
+		m := new(big.Int).SetBytes(em)
+
+		pm1 := new(big.Int).Sub(p, bigOne)
+		ep, err := PohligHellman(sig, new(big.Int).Mod(m, p), p, pm1, pf)
+		if err != nil {
+			return nil, err
+		}
+
+		qm1 := new(big.Int).Sub(q, bigOne)
+		eq, err := PohligHellman(sig, new(big.Int).Mod(m, q), q, qm1, qf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Both logs must agree modulo 2, the only factor the
+		// two moduli share
+		if ep.Bit(0) != eq.Bit(0) {
+			continue
+		}
+
+		half := new(big.Int).Rsh(qm1, 1)
+		e, lambda, err := CRT([]*big.Int{ep, new(big.Int).Mod(eq, half)}, []*big.Int{pm1, half})
+		if err != nil {
+			return nil, err
+		}
+
+		// Without a private exponent the key is no good
+		d := new(big.Int).ModInverse(e, lambda)
+		if d == nil {
+			continue
+		}
+
+		return &RSAKey{N: n, E: e, D: d}, nil
+	}
+}
+
+// LLL reduces a lattice basis, given as rows, following Cohen's integral-free
+// version of Lenstra-Lenstra-Lovász (Algorithm 2.6.3 in "A Course in
+// Computational Algebraic Number Theory"). All the arithmetic is done on
+// exact rationals: the Gram-Schmidt coefficients are updated incrementally
+// rather than recomputed after every swap. delta is the Lovász constant,
+// 3/4 is the usual choice, anything in (1/4, 1) works.
+//
+// The basis is reduced in place and returned. The rows must be linearly
+// independent
+func LLL(basis [][]*big.Rat, delta *big.Rat) ([][]*big.Rat, error) {
+
+	n := len(basis)
+	if n == 0 {
+		return basis, nil
+	}
+
+	quarter := big.NewRat(1, 4)
+	if delta.Cmp(quarter) <= 0 || delta.Cmp(big.NewRat(1, 1)) >= 0 {
+		return nil, errors.New("delta must lie in (1/4, 1)")
+	}
+
+	// bs are the Gram-Schmidt vectors, B their squared norms and
+	// mu[i][j] = <b_i, bs_j> / B_j
+	bs := make([][]*big.Rat, n)
+	B := make([]*big.Rat, n)
+	mu := make([][]*big.Rat, n)
+	for i := range mu {
+		mu[i] = make([]*big.Rat, n)
+		for j := range mu[i] {
+			mu[i][j] = new(big.Rat)
+		}
+	}
+
+	half := big.NewRat(1, 2)
+
+	// red makes |mu[k][l]| <= 1/2 by subtracting the
+	// closest integer multiple of b_l from b_k
+	red := func(k, l int) {
+		if new(big.Rat).Abs(mu[k][l]).Cmp(half) <= 0 {
+			return
+		}
+		q := ratRound(mu[k][l])
+		for i := range basis[k] {
+			basis[k][i].Sub(basis[k][i], new(big.Rat).Mul(q, basis[l][i]))
+		}
+		mu[k][l].Sub(mu[k][l], q)
+		for i := 0; i < l; i++ {
+			mu[k][i].Sub(mu[k][i], new(big.Rat).Mul(q, mu[l][i]))
+		}
+	}
+
+	kmax := 0
+	swap := func(k int) {
+		basis[k], basis[k-1] = basis[k-1], basis[k]
+		for j := 0; j < k-1; j++ {
+			mu[k][j], mu[k-1][j] = mu[k-1][j], mu[k][j]
+		}
+
+		m := new(big.Rat).Set(mu[k][k-1])
+		b := new(big.Rat).Mul(m, m)
+		b.Mul(b, B[k-1])
+		b.Add(b, B[k])
+
+		mu[k][k-1] = new(big.Rat).Mul(m, B[k-1])
+		mu[k][k-1].Quo(mu[k][k-1], b)
+
+		// New Gram-Schmidt vectors for k-1 and k
+		ratio := new(big.Rat).Quo(B[k], b)
+		prev := bs[k-1]
+		bs[k-1] = make([]*big.Rat, len(prev))
+		cur := make([]*big.Rat, len(prev))
+		for i := range prev {
+			bs[k-1][i] = new(big.Rat).Mul(m, prev[i])
+			bs[k-1][i].Add(bs[k-1][i], bs[k][i])
+
+			cur[i] = new(big.Rat).Mul(ratio, prev[i])
+			cur[i].Sub(cur[i], new(big.Rat).Mul(mu[k][k-1], bs[k][i]))
+		}
+		bs[k] = cur
+
+		B[k] = new(big.Rat).Mul(B[k-1], ratio)
+		B[k-1] = b
+
+		for i := k + 1; i <= kmax; i++ {
+			t := mu[i][k]
+			mu[i][k] = new(big.Rat).Mul(m, t)
+			mu[i][k].Sub(mu[i][k-1], mu[i][k])
+			mu[i][k-1] = new(big.Rat).Mul(mu[k][k-1], mu[i][k])
+			mu[i][k-1].Add(mu[i][k-1], t)
+		}
+	}
+
+	bs[0] = ratCopy(basis[0])
+	B[0] = ratDot(bs[0], bs[0])
+	if B[0].Sign() == 0 {
+		return nil, errors.New("basis vectors are not independent")
+	}
+
+	for k := 1; k < n; {
+		// Extend the Gram-Schmidt basis the first time b_k shows up
+		if k > kmax {
+			kmax = k
+			bs[k] = ratCopy(basis[k])
+			for j := 0; j < k; j++ {
+				mu[k][j] = ratDot(basis[k], bs[j])
+				mu[k][j].Quo(mu[k][j], B[j])
+				for i := range bs[k] {
+					bs[k][i].Sub(bs[k][i], new(big.Rat).Mul(mu[k][j], bs[j][i]))
+				}
+			}
+			B[k] = ratDot(bs[k], bs[k])
+			if B[k].Sign() == 0 {
+				return nil, errors.New("basis vectors are not independent")
+			}
+		}
+
+		red(k, k-1)
+
+		// Lovász condition: B_k >= (delta - mu^2) * B_{k-1}
+		lovasz := new(big.Rat).Mul(mu[k][k-1], mu[k][k-1])
+		lovasz.Sub(delta, lovasz)
+		lovasz.Mul(lovasz, B[k-1])
+		if B[k].Cmp(lovasz) < 0 {
+			swap(k)
+			if k > 1 {
+				k--
+			}
+			continue
+		}
+
+		for l := k - 2; l >= 0; l-- {
+			red(k, l)
+		}
+		k++
+	}
+
+	return basis, nil
+}
+
+// ratRound rounds to the closest integer, halves go up
+func ratRound(r *big.Rat) *big.Rat {
+	t := new(big.Rat).Add(r, big.NewRat(1, 2))
+	q := new(big.Int).Div(t.Num(), t.Denom())
+	return new(big.Rat).SetInt(q)
+}
+
+func ratDot(a, b []*big.Rat) *big.Rat {
+	r := new(big.Rat)
+	for i := range a {
+		r.Add(r, new(big.Rat).Mul(a[i], b[i]))
+	}
+	return r
+}
+
+func ratCopy(v []*big.Rat) []*big.Rat {
+	c := make([]*big.Rat, len(v))
+	for i := range v {
+		c[i] = new(big.Rat).Set(v[i])
+	}
+	return c
+}
+
+// SignedMessage is a message along with its ECDSA signature
+type SignedMessage struct {
+	Msg  []byte
+	R, S *big.Int
+}
+
+// NewBiasedECDSASigner returns a signer whose nonces always have
+// their low l bits set to zero.
+//
+// This function is used in Challenge 62
+func NewBiasedECDSASigner(priv *ECDSAPrivateKey, l uint) func(msg []byte) (*SignedMessage, error) {
+	return func(msg []byte) (*SignedMessage, error) {
+
+		e := hashToInt(msg, priv.N)
+		max := new(big.Int).Rsh(priv.N, l)
+
+		for {
+			k, err := crand.Int(crand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			if k.Sign() == 0 {
+				continue
+			}
+			k.Lsh(k, l)
+
+			if r, s, err := ecdsaSignWithNonce(priv, e, k); err == nil {
+				return &SignedMessage{msg, r, s}, nil
+			}
+		}
+	}
+}
+
+// Challenge 62
+// ECDSABiasedNonceAttack recovers the private key behind signatures
+// whose nonces have their low l bits set to zero. Each of them says
+// k = 2^l * b with b < q/2^l, that is d*t - u = b mod q for
+// t = r / (s*2^l) and u = -H(m) / (s*2^l): an instance of the hidden
+// number problem. The lattice spanned by the rows
+//
+//	q   0   ... 0   0    0
+//	0   q   ... 0   0    0
+//	...
+//	t1  t2  ... tn  ct   0
+//	u1  u2  ... un  0    cu
+//
+// with ct = 1/2^l and cu = q/2^l contains (b1, ..., bn, d*ct, -cu), which
+// LLL is likely to find among the short vectors of the reduced basis
+func ECDSABiasedNonceAttack(pub *ECDSAPublicKey, sigs []*SignedMessage, l uint) (*big.Int, error) {
+
+	q := pub.N
+	n := len(sigs)
+	if n == 0 {
+		return nil, errors.New("no signatures to work with")
+	}
+
+	twoL := new(big.Int).Lsh(bigOne, l)
+	qr := new(big.Rat).SetInt(q)
+	ct := new(big.Rat).SetFrac(bigOne, twoL)
+	cu := new(big.Rat).SetFrac(q, twoL)
+
+	basis := make([][]*big.Rat, n+2)
+	for i := range basis {
+		basis[i] = make([]*big.Rat, n+2)
+		for j := range basis[i] {
+			basis[i][j] = new(big.Rat)
+		}
+	}
+
+	for i, sig := range sigs {
+		basis[i][i].Set(qr)
+
+		// 1 / (s*2^l) mod q
+		inv := new(big.Int).Mul(sig.S, twoL)
+		if inv.ModInverse(inv, q) == nil {
+			return nil, errors.New("s is not invertible")
+		}
+
+		t := new(big.Int).Mul(sig.R, inv)
+		basis[n][i].SetInt(t.Mod(t, q))
+
+		u := new(big.Int).Neg(hashToInt(sig.Msg, q))
+		u.Mul(u, inv)
+		basis[n+1][i].SetInt(u.Mod(u, q))
+	}
+	basis[n][n].Set(ct)
+	basis[n+1][n+1].Set(cu)
+
+	reduced, err := LLL(basis, big.NewRat(99, 100))
+	if err != nil {
+		return nil, err
+	}
+
+	// Look for the row ending in ±cu, the one before last is ±d*ct
+	c := pub.Curve
+	for _, row := range reduced {
+		last := new(big.Rat).Abs(row[n+1])
+		if last.Cmp(cu) != 0 {
+			continue
+		}
+
+		// The vector we're after is (b, d*ct, -cu), LLL might
+		// just as well have found its opposite
+		d := new(big.Rat).Quo(row[n], ct)
+		if row[n+1].Sign() > 0 {
+			d.Neg(d)
+		}
+		if !d.IsInt() {
+			continue
+		}
+
+		x := new(big.Int).Mod(d.Num(), q)
+		if c.ScalarMult(pub.G, x).Equal(pub.Q) {
+			return x, nil
+		}
+	}
+
+	return nil, errors.New("private key not in the reduced basis, try more signatures")
+}
+
+// GF128 is an element of GF(2^128) = GF(2)[x] / (x^128 + x^7 + x^2 + x + 1).
+// Bit i of lo (i < 64) or hi (i >= 64) holds the coefficient of x^i,
+// which is how GCM reads its blocks: the first bit is x^0
+type GF128 struct {
+	lo, hi uint64
+}
+
+// GF128FromBlock reads a 16 byte block the GCM way
+func GF128FromBlock(b []byte) (GF128, error) {
+	if len(b) != 16 {
+		return GF128{}, errors.New("block must be 16 bytes")
+	}
+	return gf128FromBlock(b), nil
+}
+
+// gf128FromBlock is GF128FromBlock for the
+// blocks built here, always 16 bytes long
+func gf128FromBlock(b []byte) GF128 {
+	return GF128{
+		lo: bits.Reverse64(binary.BigEndian.Uint64(b[:8])),
+		hi: bits.Reverse64(binary.BigEndian.Uint64(b[8:16])),
+	}
+}
+
+// Block writes the element back as a 16 byte block
+func (a GF128) Block() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b[:8], bits.Reverse64(a.lo))
+	binary.BigEndian.PutUint64(b[8:], bits.Reverse64(a.hi))
+	return b
+}
+
+// IsZero tells whether a is the additive identity
+func (a GF128) IsZero() bool {
+	return a.lo == 0 && a.hi == 0
+}
+
+// Add adds (and subtracts) two elements
+func (a GF128) Add(b GF128) GF128 {
+	return GF128{a.lo ^ b.lo, a.hi ^ b.hi}
+}
+
+// Mul multiplies two elements, reducing as it goes
+func (a GF128) Mul(b GF128) GF128 {
+
+	var r GF128
+	for i := 0; i < 128; i++ {
+		w := b.lo
+		if i >= 64 {
+			w = b.hi
+		}
+		if w>>(uint(i)%64)&1 == 1 {
+			r.lo ^= a.lo
+			r.hi ^= a.hi
+		}
+
+		// a = a*x, x^128 wraps around to x^7 + x^2 + x + 1
+		carry := a.hi >> 63
+		a.hi = a.hi<<1 | a.lo>>63
+		a.lo <<= 1
+		if carry == 1 {
+			a.lo ^= 0x87
+		}
+	}
+	return r
+}
+
+// Exp raises a to the e
+func (a GF128) Exp(e *big.Int) GF128 {
+	r := GF128{lo: 1}
+	for i := e.BitLen() - 1; i >= 0; i-- {
+		r = r.Mul(r)
+		if e.Bit(i) == 1 {
+			r = r.Mul(a)
+		}
+	}
+	return r
+}
+
+// gf128Order is 2^128 - 1, the order of the multiplicative group
+var gf128Order = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 128), bigOne)
+
+// Inv returns the multiplicative inverse, a^(2^128 - 2). Zero has none
+// and stays zero
+func (a GF128) Inv() GF128 {
+	return a.Exp(new(big.Int).Sub(gf128Order, bigOne))
+}
+
+// Sqrt returns the square root, a^(2^127). Every element has one
+func (a GF128) Sqrt() GF128 {
+	for i := 0; i < 127; i++ {
+		a = a.Mul(a)
+	}
+	return a
+}
+
+// GHASH authenticates the additional data and the cipher text under
+// the key h: both are zero padded to whole blocks, followed by a block
+// holding their lengths in bits, and the blocks b1 ... bn are evaluated
+// as b1*h^n + b2*h^(n-1) + ... + bn*h
+func GHASH(h GF128, ad, cipher []byte) GF128 {
+
+	var x GF128
+	for _, b := range gcmBlocks(ad, cipher) {
+		x = x.Add(b).Mul(h)
+	}
+	return x
+}
+
+// gcmBlocks splits the GHASH input into elements of GF(2^128)
+func gcmBlocks(ad, cipher []byte) []GF128 {
+
+	blockSize := 16
+	var blocks []GF128
+
+	for _, data := range [][]byte{ad, cipher} {
+		for i := 0; i < len(data); i += blockSize {
+			b := make([]byte, blockSize)
+			copy(b, data[i:])
+			blocks = append(blocks, gf128FromBlock(b))
+		}
+	}
+
+	lengths := make([]byte, blockSize)
+	binary.BigEndian.PutUint64(lengths[:8], uint64(len(ad))*8)
+	binary.BigEndian.PutUint64(lengths[8:], uint64(len(cipher))*8)
+
+	return append(blocks, gf128FromBlock(lengths))
+}
+
+// gcmSetup derives the authentication key h = E(K, 0) and the initial
+// counter block J0 = nonce || 0^31 || 1 from a 96 bit nonce
+func gcmSetup(key, nonce []byte) (cipher.Block, GF128, []byte, error) {
+
+	blockSize := 16
+
+	if len(nonce) != 12 {
+		return nil, GF128{}, nil, errors.New("nonce must be 12 bytes")
+	}
+
+	block, err := newAESCipher(key)
+	if err != nil {
+		return nil, GF128{}, nil, err
+	}
+
+	h := make([]byte, blockSize)
+	block.Encrypt(h, h)
+
+	j0 := make([]byte, blockSize)
+	copy(j0, nonce)
+	j0[blockSize-1] = 1
+
+	return block, gf128FromBlock(h), j0, nil
+}
+
+// gcmCTR runs AES in counter mode starting from J0 + 1,
+// the counter being the last 32 bits of the block
+func gcmCTR(block cipher.Block, j0, in []byte) []byte {
+
+	blockSize := 16
+	out := make([]byte, len(in))
+	ctr := make([]byte, blockSize)
+	copy(ctr, j0)
+	ks := make([]byte, blockSize)
+
+	for i := 0; i < len(in); i += blockSize {
+		binary.BigEndian.PutUint32(ctr[12:], binary.BigEndian.Uint32(ctr[12:])+1)
+		block.Encrypt(ks, ctr)
+		for j := i; j < i+blockSize && j < len(in); j++ {
+			out[j] = in[j] ^ ks[j-i]
+		}
+	}
+
+	return out
+}
+
+// gcmTag computes GHASH(h, ad, c) + E(K, J0)
+func gcmTag(block cipher.Block, h GF128, j0, ad, c []byte) []byte {
+	s := make([]byte, 16)
+	block.Encrypt(s, j0)
+	return GHASH(h, ad, c).Add(gf128FromBlock(s)).Block()
+}
+
+// AESGCMEncrypt encrypts plain with AES-GCM using a 96 bit nonce,
+// and returns the cipher text and the 128 bit tag covering it along
+// with the additional data ad
+func AESGCMEncrypt(key, nonce, plain, ad []byte) ([]byte, []byte, error) {
+
+	block, h, j0, err := gcmSetup(key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := gcmCTR(block, j0, plain)
+
+	return c, gcmTag(block, h, j0, ad, c), nil
+}
+
+// AESGCMDecrypt checks the tag and decrypts the cipher text
+func AESGCMDecrypt(key, nonce, c, ad, tag []byte) ([]byte, error) {
+
+	block, h, j0, err := gcmSetup(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(gcmTag(block, h, j0, ad, c), tag) {
+		return nil, errors.New("message authentication failed")
+	}
+
+	return gcmCTR(block, j0, c), nil
+}
+
+// polynomial is what the algorithms shared by GFPoly and FpPoly need
+// from a polynomial ring over a field
+type polynomial interface {
+	Degree() int
+	one() polynomial
+	mul(q polynomial) polynomial
+	divMod(q polynomial) (polynomial, polynomial, error)
+	monic() polynomial
+}
+
+// polyGcd returns the monic greatest common divisor of a and b
+func polyGcd(a, b polynomial) polynomial {
+	for b.Degree() >= 0 {
+		// b is not zero, the division can't fail
+		_, r, _ := a.divMod(b)
+		a, b = b, r
+	}
+	return a.monic()
+}
+
+// polyPowMod computes p^e mod m by square and multiply
+func polyPowMod(p polynomial, e *big.Int, m polynomial) (polynomial, error) {
+	_, r, err := p.one().divMod(m)
+	if err != nil {
+		return nil, err
+	}
+	_, b, err := p.divMod(m)
+	if err != nil {
+		return nil, err
+	}
+	for i := e.BitLen() - 1; i >= 0; i-- {
+		_, r, _ = r.mul(r).divMod(m)
+		if e.Bit(i) == 1 {
+			_, r, _ = r.mul(b).divMod(m)
+		}
+	}
+	return r, nil
+}
+
+// GFPoly is a polynomial over GF(2^128), coefficients
+// go from the constant term up. The zero polynomial is empty
+type GFPoly []GF128
+
+// trim drops the leading zero coefficients
+func (p GFPoly) trim() GFPoly {
+	for len(p) > 0 && p[len(p)-1].IsZero() {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+// Degree returns the degree of p, -1 for the zero polynomial
+func (p GFPoly) Degree() int {
+	return len(p.trim()) - 1
+}
+
+// isOne tells whether p is the constant 1
+func (p GFPoly) isOne() bool {
+	p = p.trim()
+	return len(p) == 1 && p[0] == GF128{lo: 1}
+}
+
+// Add adds (and subtracts) two polynomials
+func (p GFPoly) Add(q GFPoly) GFPoly {
+	if len(p) < len(q) {
+		p, q = q, p
+	}
+	r := make(GFPoly, len(p))
+	copy(r, p)
+	for i := range q {
+		r[i] = r[i].Add(q[i])
+	}
+	return r.trim()
+}
+
+// Mul multiplies two polynomials
+func (p GFPoly) Mul(q GFPoly) GFPoly {
+	p, q = p.trim(), q.trim()
+	if len(p) == 0 || len(q) == 0 {
+		return nil
+	}
+	r := make(GFPoly, len(p)+len(q)-1)
+	for i := range p {
+		for j := range q {
+			r[i+j] = r[i+j].Add(p[i].Mul(q[j]))
+		}
+	}
+	return r.trim()
+}
+
+// DivMod divides p by q, returning quotient and remainder
+func (p GFPoly) DivMod(q GFPoly) (GFPoly, GFPoly, error) {
+
+	q = q.trim()
+	if len(q) == 0 {
+		return nil, nil, errors.New("division by zero")
+	}
+
+	rem := append(GFPoly{}, p.trim()...)
+	if len(rem) < len(q) {
+		return nil, rem, nil
+	}
+
+	quo := make(GFPoly, len(rem)-len(q)+1)
+	inv := q[len(q)-1].Inv()
+
+	for d := len(rem) - len(q); d >= 0; d-- {
+		c := rem[d+len(q)-1].Mul(inv)
+		quo[d] = c
+		for i := range q {
+			rem[d+i] = rem[d+i].Add(c.Mul(q[i]))
+		}
+	}
+
+	return quo.trim(), rem.trim(), nil
+}
+
+// Monic scales p so that its leading coefficient is 1
+func (p GFPoly) Monic() GFPoly {
+	p = p.trim()
+	if len(p) == 0 {
+		return p
+	}
+	inv := p[len(p)-1].Inv()
+	r := make(GFPoly, len(p))
+	for i := range p {
+		r[i] = p[i].Mul(inv)
+	}
+	return r
+}
+
+// Gcd returns the monic greatest common divisor of p and q
+func (p GFPoly) Gcd(q GFPoly) GFPoly {
+	return polyGcd(p.trim(), q.trim()).(GFPoly)
+}
+
+// Deriv returns the formal derivative. In characteristic 2
+// the even powers vanish
+func (p GFPoly) Deriv() GFPoly {
+	if len(p) < 2 {
+		return nil
+	}
+	r := make(GFPoly, len(p)-1)
+	for i := 1; i < len(p); i += 2 {
+		r[i-1] = p[i]
+	}
+	return r.trim()
+}
+
+// PowMod computes p^e mod m, m must not be zero
+func (p GFPoly) PowMod(e *big.Int, m GFPoly) (GFPoly, error) {
+	r, err := polyPowMod(p, e, m)
+	if err != nil {
+		return nil, err
+	}
+	return r.(GFPoly), nil
+}
+
+func (p GFPoly) one() polynomial             { return GFPoly{{lo: 1}} }
+func (p GFPoly) mul(q polynomial) polynomial { return p.Mul(q.(GFPoly)) }
+func (p GFPoly) monic() polynomial           { return p.Monic() }
+
+func (p GFPoly) divMod(q polynomial) (polynomial, polynomial, error) {
+	d, r, err := p.DivMod(q.(GFPoly))
+	return d, r, err
+}
+
+// GFFactor is a factor along with its multiplicity, or the
+// degree of its irreducible factors, depending on who's asking
+type GFFactor struct {
+	Poly GFPoly
+	N    int
+}
+
+// SquareFree splits a monic p into square free factors, each
+// along with its multiplicity
+func (p GFPoly) SquareFree() ([]GFFactor, error) {
+
+	var factors []GFFactor
+
+	c := p.Gcd(p.Deriv())
+	w, _, err := p.DivMod(c)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; !w.isOne() && w.Degree() > 0; i++ {
+		y := w.Gcd(c)
+		fac, _, err := w.DivMod(y)
+		if err != nil {
+			return nil, err
+		}
+		if !fac.isOne() {
+			factors = append(factors, GFFactor{fac, i})
+		}
+		w = y
+		if c, _, err = c.DivMod(y); err != nil {
+			return nil, err
+		}
+	}
+
+	// What's left is a perfect square: take its root
+	// and double the multiplicities
+	if c.Degree() > 0 {
+		root := make(GFPoly, c.Degree()/2+1)
+		for i := range root {
+			root[i] = c[2*i].Sqrt()
+		}
+		sf, err := root.Monic().SquareFree()
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range sf {
+			factors = append(factors, GFFactor{f.Poly, 2 * f.N})
+		}
+	}
+
+	return factors, nil
+}
+
+// DistinctDegree splits a monic square free p into factors whose
+// irreducible factors all have the same degree, N. The ones of degree i
+// are what p shares with x^(q^i) - x, q = 2^128
+func (p GFPoly) DistinctDegree() ([]GFFactor, error) {
+
+	var factors []GFFactor
+
+	x := GFPoly{{}, {lo: 1}}
+	f := p.Monic()
+	_, h, err := x.DivMod(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 1; f.Degree() >= 2*i; i++ {
+		// Raising to q is 128 squarings
+		for j := 0; j < 128; j++ {
+			if _, h, err = h.Mul(h).DivMod(f); err != nil {
+				return nil, err
+			}
+		}
+
+		g := f.Gcd(h.Add(x))
+		if !g.isOne() {
+			factors = append(factors, GFFactor{g, i})
+			if f, _, err = f.DivMod(g); err != nil {
+				return nil, err
+			}
+			if _, h, err = h.DivMod(f); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if f.Degree() > 0 {
+		factors = append(factors, GFFactor{f, f.Degree()})
+	}
+
+	return factors, nil
+}
+
+// EqualDegree splits a monic square free p, whose irreducible factors
+// all have degree d, with Cantor-Zassenhaus: for a random h, the gcd of
+// p and h^((q^d - 1)/3) - 1 is likely a proper factor. 3 always divides
+// q^d - 1 since q = 2^128 = 1 mod 3
+func (p GFPoly) EqualDegree(d int) ([]GFPoly, error) {
+
+	f := p.Monic()
+	n := f.Degree()
+	if d < 1 || n%d != 0 {
+		return nil, errors.New("degree is not a multiple of d")
+	}
+
+	e := new(big.Int).Lsh(bigOne, uint(128*d))
+	e.Sub(e, bigOne)
+	e.Div(e, big.NewInt(3))
+	one := GFPoly{{lo: 1}}
+
+	factors := []GFPoly{f}
+	for len(factors) < n/d {
+		h := make(GFPoly, n)
+		for i := range h {
+			b := make([]byte, 16)
+			if _, err := crand.Read(b); err != nil {
+				return nil, err
+			}
+			h[i] = gf128FromBlock(b)
+		}
+
+		hp, err := h.PowMod(e, f)
+		if err != nil {
+			return nil, err
+		}
+		g := hp.Add(one)
+
+		var next []GFPoly
+		for _, u := range factors {
+			if u.Degree() > d {
+				if gg := g.Gcd(u); !gg.isOne() && gg.Degree() < u.Degree() {
+					rest, _, err := u.DivMod(gg)
+					if err != nil {
+						return nil, err
+					}
+					next = append(next, gg, rest)
+					continue
+				}
+			}
+			next = append(next, u)
+		}
+		factors = next
+	}
+
+	return factors, nil
+}
+
+// Roots returns the distinct roots of p in GF(2^128)
+func (p GFPoly) Roots() ([]GF128, error) {
+
+	var roots []GF128
+
+	squareFree, err := p.Monic().SquareFree()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sf := range squareFree {
+		distinct, err := sf.Poly.DistinctDegree()
+		if err != nil {
+			return nil, err
+		}
+		for _, dd := range distinct {
+			if dd.N != 1 {
+				continue
+			}
+
+			linear, err := dd.Poly.EqualDegree(1)
+			if err != nil {
+				return nil, err
+			}
+
+			// x + a vanishes in a, as -a = a
+			for _, l := range linear {
+				roots = append(roots, l.Monic()[0])
+			}
+		}
+	}
+
+	return roots, nil
+}
+
+// gcmPoly turns a GCM message and its tag into the polynomial
+// b1*y^(n) + ... + bn*y + T, which vanishes in h up to E(K, J0)
+func gcmPoly(ad, c, tag []byte) (GFPoly, error) {
+	t, err := GF128FromBlock(tag)
+	if err != nil {
+		return nil, err
+	}
+	blocks := gcmBlocks(ad, c)
+	p := make(GFPoly, len(blocks)+1)
+	for i, b := range blocks {
+		p[len(blocks)-i] = b
+	}
+	p[0] = t
+	return p, nil
+}
+
+// GCMMessage is what goes over the wire for GCM,
+// and for ChaCha20-Poly1305 alike
+type GCMMessage struct {
+	AD, Cipher, Tag []byte
+}
+
+// Challenge 63
+// GCMForbiddenAttack recovers the candidates for the authentication key
+// h out of two messages encrypted under the same key and nonce. Adding
+// their tags, the mask E(K, J0) cancels out and leaves a polynomial in h
+// whose roots, after factoring it, are the candidates
+func GCMForbiddenAttack(m1, m2 *GCMMessage) ([]GF128, error) {
+
+	p1, err := gcmPoly(m1.AD, m1.Cipher, m1.Tag)
+	if err != nil {
+		return nil, err
+	}
+	p2, err := gcmPoly(m2.AD, m2.Cipher, m2.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	f := p1.Add(p2)
+	if f.Degree() < 1 {
+		return nil, errors.New("messages are identical")
+	}
+
+	return f.Roots()
+}
+
+// GCMForge computes the tag of a new message under the same key and
+// nonce as known, given the authentication key h: the mask is what's
+// left of the known tag once its GHASH is taken off
+func GCMForge(h GF128, known *GCMMessage, ad, c []byte) ([]byte, error) {
+	t, err := GF128FromBlock(known.Tag)
+	if err != nil {
+		return nil, err
+	}
+	s := t.Add(GHASH(h, known.AD, known.Cipher))
+	return GHASH(h, ad, c).Add(s).Block(), nil
+}
+
+// GF2Matrix is a matrix over GF(2), each row packed into 64 bit words
+type GF2Matrix struct {
+	Rows, Cols int
+	data       [][]uint64
+}
+
+// NewGF2Matrix returns a zero matrix
+func NewGF2Matrix(rows, cols int) *GF2Matrix {
+	m := &GF2Matrix{Rows: rows, Cols: cols, data: make([][]uint64, rows)}
+	for i := range m.data {
+		m.data[i] = make([]uint64, (cols+63)/64)
+	}
+	return m
+}
+
+// Bit returns the entry in row i, column j
+func (m *GF2Matrix) Bit(i, j int) uint {
+	return uint(m.data[i][j/64]>>(uint(j)%64)) & 1
+}
+
+// SetBit sets the entry in row i, column j
+func (m *GF2Matrix) SetBit(i, j int, b uint) {
+	mask := uint64(1) << (uint(j) % 64)
+	if b&1 == 1 {
+		m.data[i][j/64] |= mask
+	} else {
+		m.data[i][j/64] &^= mask
+	}
+}
+
+// xorRow adds row src of o to row dst of m
+func (m *GF2Matrix) xorRow(dst int, o *GF2Matrix, src int) {
+	for w := range m.data[dst] {
+		m.data[dst][w] ^= o.data[src][w]
+	}
+}
+
+// Mul returns m * o
+func (m *GF2Matrix) Mul(o *GF2Matrix) *GF2Matrix {
+	r := NewGF2Matrix(m.Rows, o.Cols)
+	for i := 0; i < m.Rows; i++ {
+		for j := 0; j < m.Cols; j++ {
+			if m.Bit(i, j) == 1 {
+				r.xorRow(i, o, j)
+			}
+		}
+	}
+	return r
+}
+
+// Transpose returns the transpose of m
+func (m *GF2Matrix) Transpose() *GF2Matrix {
+	t := NewGF2Matrix(m.Cols, m.Rows)
+	for i := 0; i < m.Rows; i++ {
+		for j := 0; j < m.Cols; j++ {
+			if m.Bit(i, j) == 1 {
+				t.SetBit(j, i, 1)
+			}
+		}
+	}
+	return t
+}
+
+// Copy returns a deep copy of m
+func (m *GF2Matrix) Copy() *GF2Matrix {
+	c := NewGF2Matrix(m.Rows, m.Cols)
+	for i := range m.data {
+		copy(c.data[i], m.data[i])
+	}
+	return c
+}
+
+// MulGF128 applies a 128x128 matrix to an element seen as the
+// column vector of its coefficients
+func (m *GF2Matrix) MulGF128(a GF128) GF128 {
+	var r GF128
+	for i := 0; i < m.Rows; i++ {
+		if bits.OnesCount64(m.data[i][0]&a.lo^m.data[i][1]&a.hi)&1 == 1 {
+			if i < 64 {
+				r.lo |= 1 << uint(i)
+			} else {
+				r.hi |= 1 << uint(i-64)
+			}
+		}
+	}
+	return r
+}
+
+// GaussianElimination brings m to reduced row echelon form in place,
+// returning the pivot column of each non zero row
+func (m *GF2Matrix) GaussianElimination() []int {
+
+	var pivots []int
+	row := 0
+	for col := 0; col < m.Cols && row < m.Rows; col++ {
+		p := -1
+		for i := row; i < m.Rows; i++ {
+			if m.Bit(i, col) == 1 {
+				p = i
+				break
+			}
+		}
+		if p < 0 {
+			continue
+		}
+		m.data[row], m.data[p] = m.data[p], m.data[row]
+
+		for i := 0; i < m.Rows; i++ {
+			if i != row && m.Bit(i, col) == 1 {
+				m.xorRow(i, m, row)
+			}
+		}
+		pivots = append(pivots, col)
+		row++
+	}
+
+	return pivots
+}
+
+// Rank returns the rank of m
+func (m *GF2Matrix) Rank() int {
+	return len(m.Copy().GaussianElimination())
+}
+
+// Kernel returns a basis of the vectors v such that m * v = 0,
+// one per row
+func (m *GF2Matrix) Kernel() *GF2Matrix {
+
+	r := m.Copy()
+	pivots := r.GaussianElimination()
+
+	isPivot := make(map[int]bool, len(pivots))
+	for _, p := range pivots {
+		isPivot[p] = true
+	}
+
+	k := NewGF2Matrix(m.Cols-len(pivots), m.Cols)
+	row := 0
+	for free := 0; free < m.Cols; free++ {
+		if isPivot[free] {
+			continue
+		}
+		// Set the free variable, the pivots follow from the
+		// reduced rows
+		k.SetBit(row, free, 1)
+		for i, p := range pivots {
+			k.SetBit(row, p, r.Bit(i, free))
+		}
+		row++
+	}
+
+	return k
+}
+
+// gf128Basis returns x^i
+func gf128Basis(i int) GF128 {
+	if i < 64 {
+		return GF128{lo: 1 << uint(i)}
+	}
+	return GF128{hi: 1 << uint(i-64)}
+}
+
+// GF128MulMatrix returns the matrix of the linear map y -> c*y
+func GF128MulMatrix(c GF128) *GF2Matrix {
+	m := NewGF2Matrix(128, 128)
+	for j := 0; j < 128; j++ {
+		col := c.Mul(gf128Basis(j))
+		for i := 0; i < 128; i++ {
+			if gf128Bit(col, i) == 1 {
+				m.SetBit(i, j, 1)
+			}
+		}
+	}
+	return m
+}
+
+// GF128SquareMatrix returns the matrix of y -> y^2, which is linear
+// in characteristic 2
+func GF128SquareMatrix() *GF2Matrix {
+	m := NewGF2Matrix(128, 128)
+	for j := 0; j < 128; j++ {
+		x := gf128Basis(j)
+		col := x.Mul(x)
+		for i := 0; i < 128; i++ {
+			if gf128Bit(col, i) == 1 {
+				m.SetBit(i, j, 1)
+			}
+		}
+	}
+	return m
+}
+
+// gf128Bit returns the coefficient of x^i
+func gf128Bit(a GF128, i int) uint {
+	if i < 64 {
+		return uint(a.lo>>uint(i)) & 1
+	}
+	return uint(a.hi>>uint(i-64)) & 1
+}
+
+// AESGCMEncryptTruncated is AESGCMEncrypt with the tag
+// cut down to its first tagSize bytes
+func AESGCMEncryptTruncated(key, nonce, plain, ad []byte, tagSize int) ([]byte, []byte, error) {
+
+	if tagSize < 1 || tagSize > 16 {
+		return nil, nil, errors.New("tag size must be between 1 and 16 bytes")
+	}
+
+	c, tag, err := AESGCMEncrypt(key, nonce, plain, ad)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, tag[:tagSize], nil
+}
+
+// GCMVerifier tells whether a message is authentic
+type GCMVerifier func(ad, c, tag []byte) bool
+
+// NewTruncatedGCMVerifier returns a receiver that checks truncated
+// tags, always with the same key and nonce.
+//
+// This function is used in Challenge 64
+func NewTruncatedGCMVerifier(key, nonce []byte) GCMVerifier {
+	return func(ad, c, tag []byte) bool {
+
+		block, h, j0, err := gcmSetup(key, nonce)
+		if err != nil || len(tag) == 0 || len(tag) > 16 {
+			return false
+		}
+
+		return hmac.Equal(gcmTag(block, h, j0, ad, c)[:len(tag)], tag)
+	}
+}
+
+// Challenge 64
+// GCMTruncatedMACAttack recovers the GCM authentication key h from a
+// receiver that accepts truncated tags, given one authentic message
+// whose cipher text counts at least 2^n blocks. Only the blocks that
+// GHASH multiplies by h^(2^i), i = 1...n, are altered: since squaring
+// is linear, the tag changes by Ad*h, Ad being the bit matrix
+// sum(M(d_i) * Ms^i). Choosing the d_i so that the first rows of Ad
+// vanish, forgeries get through with a probability of 2^-(tag bits -
+// rows): each one that does tells that the remaining rows of Ad are
+// orthogonal to h. Every equation found shrinks the space h lives in,
+// which lets the next rounds zero more rows, until h is the only
+// candidate left (Ferguson, "Authentication weaknesses in GCM")
+func GCMTruncatedMACAttack(verify GCMVerifier, ad, c, tag []byte, n int) (GF128, error) {
+
+	blockSize := 16
+	t := len(tag) * 8
+
+	if len(c)%blockSize != 0 || len(c)/blockSize < 1<<uint(n) {
+		return GF128{}, errors.New("cipher text needs at least 2^n whole blocks")
+	}
+	if n < 2 || n*128 <= t {
+		return GF128{}, errors.New("n is too small for the tag size")
+	}
+
+	// The block multiplied by h^(2^i): GHASH ends with the lengths
+	// block, multiplied by h, the cipher text comes right before it
+	m := len(c) / blockSize
+	index := func(i int) int {
+		return m - (1 << uint(i)) + 1
+	}
+
+	// M(x^k) * Ms^i for every bit k of every d_i, so that Ad is the
+	// sum of the ones whose bit is set
+	ms := GF128SquareMatrix()
+	msi := ms
+	basis := make([]*GF2Matrix, 0, n*128)
+	for i := 1; i <= n; i++ {
+		for k := 0; k < 128; k++ {
+			basis = append(basis, GF128MulMatrix(gf128Basis(k)).Mul(msi))
+		}
+		msi = msi.Mul(ms)
+	}
+
+	// K gathers what's known of h: K * h = 0. X spans its kernel
+	known := NewGF2Matrix(0, 128)
+	x := identityGF2(128)
+
+	for x.Cols > 1 {
+		// Zero as many rows as the free bits allow, keeping at
+		// least one to learn from
+		rows := (n*128 - 1) / x.Cols
+		if rows > t-1 {
+			rows = t - 1
+		}
+
+		// The dependency matrix: column b holds the first rows of
+		// basis[b] * X, flattened
+		dep := NewGF2Matrix(rows*x.Cols, n*128)
+		for b, mb := range basis {
+			prod := NewGF2Matrix(rows, x.Cols)
+			for r := 0; r < rows; r++ {
+				for j := 0; j < 128; j++ {
+					if mb.Bit(r, j) == 1 {
+						prod.xorRow(r, x, j)
+					}
+				}
+				for col := 0; col < x.Cols; col++ {
+					if prod.Bit(r, col) == 1 {
+						dep.SetBit(r*x.Cols+col, b, 1)
+					}
+				}
+			}
+		}
+		kernel := dep.Kernel()
+		if kernel.Rows == 0 {
+			return GF128{}, errors.New("no way to zero the rows")
+		}
+
+		// Throw random combinations of the kernel at the receiver
+		var ad2 *GF2Matrix
+		for ad2 == nil {
+			v, err := randomCombination(kernel)
+			if err != nil {
+				return GF128{}, err
+			}
+
+			forged := append([]byte{}, c...)
+			for i := 1; i <= n; i++ {
+				d := make([]byte, blockSize)
+				var e GF128
+				for k := 0; k < 128; k++ {
+					if v[((i-1)*128+k)/64]>>(uint((i-1)*128+k)%64)&1 == 1 {
+						e = e.Add(gf128Basis(k))
+					}
+				}
+				copy(d, e.Block())
+				off := index(i) * blockSize
+				for j := 0; j < blockSize; j++ {
+					forged[off+j] ^= d[j]
+				}
+			}
+
+			if !verify(ad, forged, tag) {
+				continue
+			}
+
+			ad2 = NewGF2Matrix(128, 128)
+			for b := range basis {
+				if v[b/64]>>(uint(b)%64)&1 == 1 {
+					for r := 0; r < 128; r++ {
+						ad2.xorRow(r, basis[b], r)
+					}
+				}
+			}
+		}
+
+		// The rows left alone must be orthogonal to h too
+		next := NewGF2Matrix(known.Rows+t-rows, 128)
+		for r := 0; r < known.Rows; r++ {
+			next.xorRow(r, known, r)
+		}
+		for r := rows; r < t; r++ {
+			next.xorRow(known.Rows+r-rows, ad2, r)
+		}
+		known = next
+		x = known.Kernel().Transpose()
+	}
+
+	if x.Cols == 0 {
+		return GF128{}, errors.New("no key satisfies the equations")
+	}
+
+	var h GF128
+	for i := 0; i < 128; i++ {
+		if x.Bit(i, 0) == 1 {
+			h = h.Add(gf128Basis(i))
+		}
+	}
+
+	return h, nil
+}
+
+// identityGF2 returns the n x n identity
+func identityGF2(n int) *GF2Matrix {
+	m := NewGF2Matrix(n, n)
+	for i := 0; i < n; i++ {
+		m.SetBit(i, i, 1)
+	}
+	return m
+}
+
+// randomCombination adds up a random non empty subset of the rows of m
+func randomCombination(m *GF2Matrix) ([]uint64, error) {
+
+	v := make([]uint64, (m.Cols+63)/64)
+	pick := make([]byte, (m.Rows+7)/8)
+
+	for {
+		if _, err := crand.Read(pick); err != nil {
+			return nil, err
+		}
+
+		nonZero := false
+		for i := 0; i < m.Rows; i++ {
+			if pick[i/8]>>(uint(i)%8)&1 == 1 {
+				for w := range v {
+					v[w] ^= m.data[i][w]
+				}
+			}
+		}
+		for _, w := range v {
+			nonZero = nonZero || w != 0
+		}
+		if nonZero {
+			return v, nil
+		}
+	}
+}
+
+// ChaCha20 holds the state of the ChaCha20 stream cipher (RFC 8439)
+type ChaCha20 struct {
+	state  [16]uint32
+	stream [64]byte
+	off    int
+}
+
+// NewChaCha20 returns a cipher keyed with a 256 bit key and a 96 bit
+// nonce, whose key stream starts at the given block counter
+func NewChaCha20(key, nonce []byte, counter uint32) (*ChaCha20, error) {
+
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w, use 32 bytes", ErrKeySize)
+	}
+	if len(nonce) != 12 {
+		return nil, errors.New("invalid nonce size, use 12 bytes")
+	}
+
+	c := &ChaCha20{off: 64}
+	// "expand 32-byte k"
+	c.state[0], c.state[1], c.state[2], c.state[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	for i := 0; i < 8; i++ {
+		c.state[4+i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	c.state[12] = counter
+	for i := 0; i < 3; i++ {
+		c.state[13+i] = binary.LittleEndian.Uint32(nonce[4*i:])
+	}
+
+	return c, nil
+}
+
+// chachaQuarterRound mixes four words of the state
+func chachaQuarterRound(x *[16]uint32, a, b, c, d int) {
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 16)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 12)
+	x[a] += x[b]
+	x[d] = bits.RotateLeft32(x[d]^x[a], 8)
+	x[c] += x[d]
+	x[b] = bits.RotateLeft32(x[b]^x[c], 7)
+}
+
+// block fills the key stream buffer and moves to the next counter
+func (c *ChaCha20) block() {
+
+	x := c.state
+	for i := 0; i < 10; i++ {
+		// Columns, then diagonals
+		chachaQuarterRound(&x, 0, 4, 8, 12)
+		chachaQuarterRound(&x, 1, 5, 9, 13)
+		chachaQuarterRound(&x, 2, 6, 10, 14)
+		chachaQuarterRound(&x, 3, 7, 11, 15)
+		chachaQuarterRound(&x, 0, 5, 10, 15)
+		chachaQuarterRound(&x, 1, 6, 11, 12)
+		chachaQuarterRound(&x, 2, 7, 8, 13)
+		chachaQuarterRound(&x, 3, 4, 9, 14)
+	}
+
+	for i := range x {
+		binary.LittleEndian.PutUint32(c.stream[4*i:], x[i]+c.state[i])
+	}
+	c.state[12]++
+	c.off = 0
+}
+
+// XORKeyStream xors src with the key stream and stores the result in dst.
+// dst and src may overlap entirely
+func (c *ChaCha20) XORKeyStream(dst, src []byte) {
+	for k := 0; k < len(src); k++ {
+		if c.off == len(c.stream) {
+			c.block()
+		}
+		dst[k] = src[k] ^ c.stream[c.off]
+		c.off++
+	}
+}
+
+// ChaCha20Encrypt encrypts (or decrypts) in, starting from
+// block counter 1 as the AEAD construction does
+func ChaCha20Encrypt(in, key, nonce []byte) ([]byte, error) {
+
+	c, err := NewChaCha20(key, nonce, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(in))
+	c.XORKeyStream(out, in)
+
+	return out, nil
+}
+
+// poly1305P is the prime 2^130 - 5
+var poly1305P = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 130), big.NewInt(5))
+
+// poly1305Clamp has the bits of r that clamping leaves alone
+var poly1305Clamp = bigFromString("0x0ffffffc0ffffffc0ffffffc0fffffff")
+
+// leToInt reads a little endian number
+func leToInt(b []byte) *big.Int {
+	be := make([]byte, len(b))
+	for i := range b {
+		be[len(b)-1-i] = b[i]
+	}
+	return new(big.Int).SetBytes(be)
+}
+
+// intToLE writes the n low bytes of x, little endian
+func intToLE(x *big.Int, n int) []byte {
+	be := x.Bytes()
+	out := make([]byte, n)
+	for i := 0; i < n && i < len(be); i++ {
+		out[i] = be[len(be)-1-i]
+	}
+	return out
+}
+
+// poly1305Blocks splits msg into 16 byte blocks, each read as a little
+// endian number with a 1 bit set right past its last byte
+func poly1305Blocks(msg []byte) []*big.Int {
+	var blocks []*big.Int
+	for i := 0; i < len(msg); i += 16 {
+		end := i + 16
+		if end > len(msg) {
+			end = len(msg)
+		}
+		n := leToInt(msg[i:end])
+		n.SetBit(n, 8*(end-i), 1)
+		blocks = append(blocks, n)
+	}
+	return blocks
+}
+
+// Poly1305 computes the one time authenticator of msg under a 32 byte
+// key r || s: the tag is the message evaluated as a polynomial in the
+// clamped r modulo 2^130 - 5, plus s modulo 2^128
+func Poly1305(msg, key []byte) ([]byte, error) {
+
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w, use 32 bytes", ErrKeySize)
+	}
+
+	r := leToInt(key[:16])
+	r.And(r, poly1305Clamp)
+	s := leToInt(key[16:])
+
+	acc := new(big.Int)
+	for _, n := range poly1305Blocks(msg) {
+		acc.Add(acc, n)
+		acc.Mul(acc, r)
+		acc.Mod(acc, poly1305P)
+	}
+	acc.Add(acc, s)
+
+	return intToLE(acc, 16), nil
+}
+
+// chachaPolyInput lays out what the AEAD construction authenticates:
+// the additional data and the cipher text, each padded to 16 bytes,
+// followed by their lengths
+func chachaPolyInput(ad, c []byte) []byte {
+	pad := func(n int) []byte {
+		return make([]byte, (16-n%16)%16)
+	}
+
+	in := make([]byte, 0, len(ad)+len(c)+48)
+	in = append(in, ad...)
+	in = append(in, pad(len(ad))...)
+	in = append(in, c...)
+	in = append(in, pad(len(c))...)
+
+	lens := make([]byte, 16)
+	binary.LittleEndian.PutUint64(lens, uint64(len(ad)))
+	binary.LittleEndian.PutUint64(lens[8:], uint64(len(c)))
+
+	return append(in, lens...)
+}
+
+// chachaPolyKey derives the one time Poly1305 key from
+// the first block of key stream
+func chachaPolyKey(key, nonce []byte) ([]byte, error) {
+	c, err := NewChaCha20(key, nonce, 0)
+	if err != nil {
+		return nil, err
+	}
+	polyKey := make([]byte, 32)
+	c.XORKeyStream(polyKey, polyKey)
+	return polyKey, nil
+}
+
+// ChaCha20Poly1305Encrypt encrypts and authenticates plain along with
+// the additional data ad, returning cipher text and tag
+func ChaCha20Poly1305Encrypt(key, nonce, plain, ad []byte) ([]byte, []byte, error) {
+
+	polyKey, err := chachaPolyKey(key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := ChaCha20Encrypt(plain, key, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tag, err := Poly1305(chachaPolyInput(ad, c), polyKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, tag, nil
+}
+
+// ChaCha20Poly1305Decrypt checks the tag and decrypts c
+func ChaCha20Poly1305Decrypt(key, nonce, c, ad, tag []byte) ([]byte, error) {
+
+	polyKey, err := chachaPolyKey(key, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	want, err := Poly1305(chachaPolyInput(ad, c), polyKey)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(want, tag) {
+		return nil, errors.New("message authentication failed")
+	}
+
+	return ChaCha20Encrypt(c, key, nonce)
+}
+
+// FpPoly is a polynomial over GF(2^130 - 5), coefficients
+// go from the constant term up. The zero polynomial is empty
+type FpPoly []*big.Int
+
+// trim drops the leading zero coefficients
+func (p FpPoly) trim() FpPoly {
+	for len(p) > 0 && p[len(p)-1].Sign() == 0 {
+		p = p[:len(p)-1]
+	}
+	return p
+}
+
+// Degree returns the degree of p, -1 for the zero polynomial
+func (p FpPoly) Degree() int {
+	return len(p.trim()) - 1
+}
+
+// isOne tells whether p is the constant 1
+func (p FpPoly) isOne() bool {
+	p = p.trim()
+	return len(p) == 1 && p[0].Cmp(bigOne) == 0
+}
+
+// Sub returns p - q
+func (p FpPoly) Sub(q FpPoly) FpPoly {
+	n := len(p)
+	if len(q) > n {
+		n = len(q)
+	}
+	r := make(FpPoly, n)
+	for i := range r {
+		r[i] = new(big.Int)
+		if i < len(p) {
+			r[i].Add(r[i], p[i])
+		}
+		if i < len(q) {
+			r[i].Sub(r[i], q[i])
+		}
+		r[i].Mod(r[i], poly1305P)
+	}
+	return r.trim()
+}
+
+// Mul multiplies two polynomials
+func (p FpPoly) Mul(q FpPoly) FpPoly {
+	p, q = p.trim(), q.trim()
+	if len(p) == 0 || len(q) == 0 {
+		return nil
+	}
+	r := make(FpPoly, len(p)+len(q)-1)
+	for i := range r {
+		r[i] = new(big.Int)
+	}
+	t := new(big.Int)
+	for i := range p {
+		for j := range q {
+			r[i+j].Add(r[i+j], t.Mul(p[i], q[j]))
+		}
+	}
+	for i := range r {
+		r[i].Mod(r[i], poly1305P)
+	}
+	return r.trim()
+}
+
+// DivMod divides p by q, returning quotient and remainder
+func (p FpPoly) DivMod(q FpPoly) (FpPoly, FpPoly, error) {
+
+	q = q.trim()
+	if len(q) == 0 {
+		return nil, nil, errors.New("division by zero")
+	}
+
+	rem := make(FpPoly, len(p.trim()))
+	for i := range rem {
+		rem[i] = new(big.Int).Set(p[i])
+	}
+	if len(rem) < len(q) {
+		return nil, rem, nil
+	}
+
+	quo := make(FpPoly, len(rem)-len(q)+1)
+	inv := new(big.Int).ModInverse(q[len(q)-1], poly1305P)
+	t := new(big.Int)
+
+	for d := len(rem) - len(q); d >= 0; d-- {
+		c := new(big.Int).Mul(rem[d+len(q)-1], inv)
+		c.Mod(c, poly1305P)
+		quo[d] = c
+		for i := range q {
+			rem[d+i].Sub(rem[d+i], t.Mul(c, q[i]))
+			rem[d+i].Mod(rem[d+i], poly1305P)
+		}
+	}
+
+	return quo.trim(), rem.trim(), nil
+}
+
+// Monic scales p so that its leading coefficient is 1
+func (p FpPoly) Monic() FpPoly {
+	p = p.trim()
+	if len(p) == 0 {
+		return p
+	}
+	inv := new(big.Int).ModInverse(p[len(p)-1], poly1305P)
+	r := make(FpPoly, len(p))
+	for i := range p {
+		r[i] = new(big.Int).Mul(p[i], inv)
+		r[i].Mod(r[i], poly1305P)
+	}
+	return r
+}
+
+// Gcd returns the monic greatest common divisor of p and q
+func (p FpPoly) Gcd(q FpPoly) FpPoly {
+	return polyGcd(p.trim(), q.trim()).(FpPoly)
+}
+
+// PowMod computes p^e mod m, m must not be zero
+func (p FpPoly) PowMod(e *big.Int, m FpPoly) (FpPoly, error) {
+	r, err := polyPowMod(p, e, m)
+	if err != nil {
+		return nil, err
+	}
+	return r.(FpPoly), nil
+}
+
+func (p FpPoly) one() polynomial             { return FpPoly{big.NewInt(1)} }
+func (p FpPoly) mul(q polynomial) polynomial { return p.Mul(q.(FpPoly)) }
+func (p FpPoly) monic() polynomial           { return p.Monic() }
+
+func (p FpPoly) divMod(q polynomial) (polynomial, polynomial, error) {
+	d, r, err := p.DivMod(q.(FpPoly))
+	return d, r, err
+}
+
+// Roots returns the distinct roots of p. They are the roots of
+// gcd(p, x^q - x), which gets split with random x + a: about half of
+// the roots make (x + a)^((q-1)/2) equal to 1
+func (p FpPoly) Roots() ([]*big.Int, error) {
+
+	f := p.Monic()
+	if f.Degree() < 1 {
+		return nil, nil
+	}
+
+	x := FpPoly{big.NewInt(0), big.NewInt(1)}
+	xq, err := x.PowMod(poly1305P, f)
+	if err != nil {
+		return nil, err
+	}
+	g := f.Gcd(xq.Sub(x))
+
+	half := new(big.Int).Rsh(poly1305P, 1)
+	one := FpPoly{big.NewInt(1)}
+
+	var roots []*big.Int
+	todo := []FpPoly{g}
+	for len(todo) > 0 {
+		u := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		switch u.Degree() {
+		case 0:
+			continue
+		case 1:
+			// x + a vanishes in -a
+			roots = append(roots, new(big.Int).Mod(new(big.Int).Neg(u[0]), poly1305P))
+			continue
+		}
+
+		for {
+			a, err := crand.Int(crand.Reader, poly1305P)
+			if err != nil {
+				return nil, err
+			}
+			h, err := FpPoly{a, big.NewInt(1)}.PowMod(half, u)
+			if err != nil {
+				return nil, err
+			}
+			if d := u.Gcd(h.Sub(one)); d.Degree() > 0 && d.Degree() < u.Degree() {
+				rest, _, err := u.DivMod(d)
+				if err != nil {
+					return nil, err
+				}
+				todo = append(todo, d, rest)
+				break
+			}
+		}
+	}
+
+	return roots, nil
+}
+
+// poly1305Poly turns msg into the polynomial Poly1305 evaluates in r,
+// before adding s
+func poly1305Poly(msg []byte) FpPoly {
+	blocks := poly1305Blocks(msg)
+	p := make(FpPoly, len(blocks)+1)
+	p[0] = new(big.Int)
+	for i, n := range blocks {
+		p[len(blocks)-i] = n
+	}
+	return p
+}
+
+// Challenge 65
+// Poly1305KeyReuseAttack recovers the candidates for a Poly1305 key used
+// on two different messages. Subtracting the tags cancels s, but the
+// tags are reduced mod 2^128 after the evaluation mod 2^130 - 5: the
+// difference of the evaluations is the difference of the tags, give or
+// take up to four times 2^128. Each of the nine polynomials that makes
+// gets its roots taken; clamping rules out nearly all the wrong ones,
+// and s follows from either tag
+func Poly1305KeyReuseAttack(m1, t1, m2, t2 []byte) ([][]byte, error) {
+
+	if len(t1) != 16 || len(t2) != 16 {
+		return nil, errors.New("tags must be 16 bytes long")
+	}
+
+	p1, p2 := poly1305Poly(m1), poly1305Poly(m2)
+	diff := new(big.Int).Sub(leToInt(t1), leToInt(t2))
+	mod128 := new(big.Int).Lsh(bigOne, 128)
+
+	base := p1.Sub(p2)
+	if base.Degree() < 1 {
+		return nil, errors.New("messages are identical")
+	}
+
+	var keys [][]byte
+	seen := make(map[string]bool)
+
+	for k := int64(-4); k <= 4; k++ {
+		c := new(big.Int).Mul(big.NewInt(k), mod128)
+		c.Add(c, diff)
+		c.Mod(c, poly1305P)
+
+		roots, err := base.Sub(FpPoly{c}).Roots()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range roots {
+			if new(big.Int).AndNot(r, poly1305Clamp).Sign() != 0 {
+				continue
+			}
+
+			// s = t1 - P1(r) mod 2^128
+			s := new(big.Int).Sub(leToInt(t1), fpEval(p1, r))
+			s.Mod(s, mod128)
+
+			key := append(intToLE(r, 16), intToLE(s, 16)...)
+			if seen[string(key)] {
+				continue
+			}
+
+			// Both tags must check out
+			tag, err := Poly1305(m2, key)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(tag, t2) {
+				continue
+			}
+
+			seen[string(key)] = true
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no key fits both messages")
+	}
+
+	return keys, nil
+}
+
+// fpEval evaluates p in x
+func fpEval(p FpPoly, x *big.Int) *big.Int {
+	r := new(big.Int)
+	for i := len(p) - 1; i >= 0; i-- {
+		r.Mul(r, x)
+		r.Add(r, p[i])
+		r.Mod(r, poly1305P)
+	}
+	return r
+}
+
+// ChaCha20Poly1305NonceReuseAttack recovers the one time Poly1305 key out
+// of two messages sealed under the same key and nonce, which can then
+// authenticate anything under that nonce with ChaCha20Poly1305Forge
+func ChaCha20Poly1305NonceReuseAttack(m1, m2 *GCMMessage) ([][]byte, error) {
+	return Poly1305KeyReuseAttack(
+		chachaPolyInput(m1.AD, m1.Cipher), m1.Tag,
+		chachaPolyInput(m2.AD, m2.Cipher), m2.Tag)
+}
+
+// ChaCha20Poly1305Forge computes the tag of a cipher text given
+// the recovered Poly1305 key
+func ChaCha20Poly1305Forge(polyKey, ad, c []byte) ([]byte, error) {
+	return Poly1305(chachaPolyInput(ad, c), polyKey)
+}
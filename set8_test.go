@@ -0,0 +1,859 @@
+package matasano
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestSmallFactors(t *testing.T) {
+	// 2^3 * 3 * 5^2 * 65537
+	n := big.NewInt(8 * 3 * 25 * 65537)
+	want := []int64{2, 3, 5}
+
+	got := SmallFactors(n, 1<<16)
+	if len(got) != len(want) {
+		t.Logf("got: %v, want: %v", got, want)
+		t.FailNow()
+	}
+	for i := range want {
+		if got[i].Int64() != want[i] {
+			t.Logf("got: %v, want: %v", got, want)
+			t.FailNow()
+		}
+	}
+}
+
+func TestProblem57(t *testing.T) {
+	x, _, err := DHGenerateKey(Ch57P, Ch57G, Ch57Q)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	got, err := DHSubgroupConfinementAttack(NewDHBobOracle(Ch57P, x), Ch57P, Ch57Q)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got.Cmp(x) != 0 {
+		t.Logf("got: %v, want: %v", got, x)
+		t.FailNow()
+	}
+}
+
+func TestKangaroo(t *testing.T) {
+	tests := []struct {
+		y    string
+		b    uint
+		want int64
+	}{
+		{"7760073848032689505395005705677365876654629189298052775754597607446617558600394076764814236081991643094239886772481052254010323780165093955236429914607119", 20, 705485},
+		{"9388897478013399550694114614498790691034187453089355259602614074132918843899833277397448144245883225611726912025846772975325932794909655215329941809013733", 40, 359579674340},
+	}
+
+	for i, test := range tests {
+		// A 2^40 interval takes a while
+		if test.b > 20 && !*full {
+			continue
+		}
+
+		y, _ := new(big.Int).SetString(test.y, 10)
+		b := new(big.Int).Lsh(bigOne, test.b)
+
+		got, err := Kangaroo(Ch58P, Ch58G, y, bigZero, b, nil)
+		if err != nil {
+			t.Logf("test %d: %v", i, err)
+			t.FailNow()
+		}
+
+		if got.Int64() != test.want {
+			t.Logf("test %d: got: %v, want: %v", i, got, test.want)
+			t.FailNow()
+		}
+	}
+
+	// Far too wide to walk, unless told when to give up
+	wide := new(big.Int).Lsh(bigOne, 100)
+	if _, err := Kangaroo(Ch58P, Ch58G, Ch58G, bigZero, wide, nil); err == nil {
+		t.Log("walked a 2^100 interval")
+		t.FailNow()
+	}
+}
+
+func TestProblem58(t *testing.T) {
+	x, y, err := DHGenerateKey(Ch58P, Ch58G, Ch58Q)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	got, err := DHKangarooAttack(NewDHBobOracle(Ch58P, x), Ch58P, Ch58G, Ch58Q, y, nil)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got.Cmp(x) != 0 {
+		t.Logf("got: %v, want: %v", got, x)
+		t.FailNow()
+	}
+}
+
+func TestWeierstrassCurve(t *testing.T) {
+	c := Ch59Curve
+
+	if !c.IsOnCurve(Ch59G) {
+		t.Log("base point is not on the curve")
+		t.FailNow()
+	}
+
+	if pt := c.ScalarMult(Ch59G, Ch59Q); !pt.IsInfinity() {
+		t.Logf("q*G: %v, want: infinity", pt)
+		t.FailNow()
+	}
+
+	k := big.NewInt(123456789)
+	jacobian := c.ScalarMult(Ch59G, k)
+	affine := c.ScalarMultAffine(Ch59G, k)
+	if !jacobian.Equal(affine) || !c.IsOnCurve(jacobian) {
+		t.Logf("jacobian: %v, affine: %v", jacobian, affine)
+		t.FailNow()
+	}
+
+	if order := c.PointOrder(Ch59G, Ch59Order, []*big.Int{bigTwo, Ch59Q}); order.Cmp(Ch59Q) != 0 {
+		t.Logf("got: %v, want: %v", order, Ch59Q)
+		t.FailNow()
+	}
+}
+
+func TestProblem59(t *testing.T) {
+	x, _, err := ECDHGenerateKey(Ch59Curve, Ch59G, Ch59Q)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	oracle := NewECDHBobOracle(Ch59Curve, x)
+	got, err := ECInvalidCurveAttack(oracle, Ch59Curve, Ch59Q, Ch59InvalidCurves)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got.Cmp(x) != 0 {
+		t.Logf("got: %v, want: %v", got, x)
+		t.FailNow()
+	}
+}
+
+func TestMontgomeryCurve(t *testing.T) {
+	c := Ch60Curve
+
+	w := c.ToWeierstrass()
+	if w.A.Cmp(Ch59Curve.mod(new(big.Int).Set(Ch59Curve.A))) != 0 || w.B.Cmp(Ch59Curve.B) != 0 {
+		t.Logf("got: a = %v, b = %v", w.A, w.B)
+		t.FailNow()
+	}
+
+	if u := c.Ladder(Ch60G, Ch59Q); u.Sign() != 0 {
+		t.Logf("u(q*G): %v, want: 0", u)
+		t.FailNow()
+	}
+
+	k := big.NewInt(987654321)
+	pt := Ch59Curve.ScalarMult(Ch59G, k)
+	u, _ := c.PointFromWeierstrass(pt)
+	if got := c.Ladder(Ch60G, k); got.Cmp(u) != 0 {
+		t.Logf("ladder: %v, weierstrass: %v", got, u)
+		t.FailNow()
+	}
+
+	if v := c.LiftX(u); !c.PointToWeierstrass(u, v).Equal(pt) && !c.PointToWeierstrass(u, v).Equal(Ch59Curve.Neg(pt)) {
+		t.Logf("%v does not map back to %v", u, pt)
+		t.FailNow()
+	}
+}
+
+func TestECKangaroo(t *testing.T) {
+	want := big.NewInt(123456)
+	y := Ch59Curve.ScalarMult(Ch59G, want)
+
+	got, err := ECKangaroo(Ch59Curve, Ch59G, y, big.NewInt(100000), big.NewInt(1<<17), nil)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got.Cmp(want) != 0 {
+		t.Logf("got: %v, want: %v", got, want)
+		t.FailNow()
+	}
+}
+
+func TestProblem60(t *testing.T) {
+	x, _, err := ECDHGenerateKey(Ch59Curve, Ch59G, Ch59Q)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	pub := Ch60Curve.Ladder(Ch60G, x)
+	oracle := NewMontgomeryBobOracle(Ch60Curve, x)
+
+	// The kangaroo has to cover about 2^40 keys at the end,
+	// short of a full run only the twist part is checked
+	if !*full {
+		res, mod, err := MontgomeryTwistResidues(oracle, Ch60Curve, Ch59Order, 1<<22)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+
+		r := new(big.Int).Mod(x, mod)
+		if r.Cmp(res) != 0 && r.Cmp(new(big.Int).Sub(mod, res)) != 0 {
+			t.Logf("got: ±%v, want: %v mod %v", res, r, mod)
+			t.FailNow()
+		}
+		return
+	}
+
+	got, err := MontgomeryTwistAttack(oracle, Ch60Curve, Ch60G, pub, Ch59Order, Ch59Q, 1<<22, nil)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got.Cmp(x) != 0 && got.Cmp(new(big.Int).Sub(Ch59Q, x)) != 0 {
+		t.Logf("got: %v, want: %v", got, x)
+		t.FailNow()
+	}
+}
+
+func TestMontgomeryTwistAttackResidues(t *testing.T) {
+	// A key below a made up order q smaller than what the twist gives:
+	// the residues are enough, no kangaroo needed
+	q := big.NewInt(1 << 20)
+	for _, x := range []*big.Int{big.NewInt(31337), big.NewInt(1<<20 - 5)} {
+		pub := Ch60Curve.Ladder(Ch60G, x)
+		oracle := NewMontgomeryBobOracle(Ch60Curve, x)
+
+		got, err := MontgomeryTwistAttack(oracle, Ch60Curve, Ch60G, pub, Ch59Order, q, 1<<22, nil)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if got.Cmp(x) != 0 {
+			t.Logf("got: %v, want: %v", got, x)
+			t.FailNow()
+		}
+	}
+}
+
+func TestPohligHellman(t *testing.T) {
+	// p-1 = 2^2 * 3 * 5^2 * 7 * 11 * 13
+	p := big.NewInt(300301)
+	g := big.NewInt(2)
+	primes := []*big.Int{big.NewInt(2), big.NewInt(3), big.NewInt(5), big.NewInt(7), big.NewInt(11), big.NewInt(13)}
+	order := big.NewInt(300300)
+
+	if !p.ProbablyPrime(0) || !isPrimitiveRoot(g, p, primes) {
+		t.Log("bad test parameters")
+		t.FailNow()
+	}
+
+	want := big.NewInt(123456)
+	h := new(big.Int).Exp(g, want, p)
+
+	got, err := PohligHellman(g, h, p, order, primes)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got.Cmp(want) != 0 {
+		t.Logf("got: %v, want: %v", got, want)
+		t.FailNow()
+	}
+}
+
+func TestECDSA(t *testing.T) {
+	msg := []byte("hi mom")
+
+	priv, err := ECDSAGenerateKey(Ch59Curve, Ch59G, Ch59Q)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	r, s, err := ECDSASign(priv, msg)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if !ECDSAVerify(&priv.ECDSAPublicKey, msg, r, s) {
+		t.Log("valid signature rejected")
+		t.FailNow()
+	}
+
+	if ECDSAVerify(&priv.ECDSAPublicKey, []byte("hi dad"), r, s) {
+		t.Log("signature of another message accepted")
+		t.FailNow()
+	}
+}
+
+func TestProblem61(t *testing.T) {
+	msg := []byte("hi mom")
+
+	priv, err := ECDSAGenerateKey(Ch59Curve, Ch59G, Ch59Q)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	r, s, err := ECDSASign(priv, msg)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	forged, err := ECDSAKeySelection(&priv.ECDSAPublicKey, msg, r, s)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if forged.Q.Equal(priv.Q) || !ECDSAVerify(&forged.ECDSAPublicKey, msg, r, s) {
+		t.Log("ECDSA signature does not verify under a new key")
+		t.FailNow()
+	}
+
+	if !forged.Curve.ScalarMult(forged.G, forged.D).Equal(forged.Q) {
+		t.Log("forged ECDSA key is not consistent")
+		t.FailNow()
+	}
+
+	key, err := RSAGenerateKey(1024)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	sig, err := RSASign(msg, key)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	rsaForged, err := RSAKeySelection(key.Public(), msg, sig)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if rsaForged.N.Cmp(key.N) == 0 || !RSAVerify(msg, sig, rsaForged.Public()) {
+		t.Log("RSA signature does not verify under a new key")
+		t.FailNow()
+	}
+
+	// The private exponent must work too
+	if resig, err := RSASign(msg, rsaForged); err != nil || resig.Cmp(sig) != 0 {
+		t.Logf("re-signing with the forged key: %v, %v", resig, err)
+		t.FailNow()
+	}
+}
+
+func TestLLL(t *testing.T) {
+	// The example from the challenge
+	basis := [][]*big.Rat{
+		{big.NewRat(-2, 1), big.NewRat(0, 1), big.NewRat(2, 1), big.NewRat(0, 1)},
+		{big.NewRat(1, 2), big.NewRat(-1, 1), big.NewRat(0, 1), big.NewRat(0, 1)},
+		{big.NewRat(-1, 1), big.NewRat(0, 1), big.NewRat(-2, 1), big.NewRat(1, 2)},
+		{big.NewRat(-1, 1), big.NewRat(1, 1), big.NewRat(1, 1), big.NewRat(2, 1)},
+	}
+	want := [][]*big.Rat{
+		{big.NewRat(1, 2), big.NewRat(-1, 1), big.NewRat(0, 1), big.NewRat(0, 1)},
+		{big.NewRat(-1, 1), big.NewRat(0, 1), big.NewRat(-2, 1), big.NewRat(1, 2)},
+		{big.NewRat(-1, 2), big.NewRat(0, 1), big.NewRat(1, 1), big.NewRat(2, 1)},
+		{big.NewRat(-3, 2), big.NewRat(-1, 1), big.NewRat(2, 1), big.NewRat(0, 1)},
+	}
+
+	got, err := LLL(basis, big.NewRat(99, 100))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	for i := range want {
+		for j := range want[i] {
+			if got[i][j].Cmp(want[i][j]) != 0 {
+				t.Logf("got: %v, want: %v", got, want)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestProblem62(t *testing.T) {
+	priv, err := ECDSAGenerateKey(Ch59Curve, Ch59G, Ch59Q)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	sign := NewBiasedECDSASigner(priv, 8)
+
+	var sigs []*SignedMessage
+	for i := 0; i < 22; i++ {
+		sig, err := sign([]byte(fmt.Sprintf("message %d", i)))
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		sigs = append(sigs, sig)
+	}
+
+	got, err := ECDSABiasedNonceAttack(&priv.ECDSAPublicKey, sigs, 8)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if got.Cmp(priv.D) != 0 {
+		t.Logf("got: %v, want: %v", got, priv.D)
+		t.FailNow()
+	}
+}
+
+func TestAESGCM(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	nonce := []byte("123456789012")
+	plain := []byte("Hello world, I've coded quite alot lately...")
+	ad := []byte("some additional data")
+
+	c, tag, err := AESGCMEncrypt(key, nonce, plain, ad)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// Check against the standard library
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if want := gcm.Seal(nil, nonce, plain, ad); !bytes.Equal(append(c, tag...), want) {
+		t.Logf("got: %x, want: %x", append(c, tag...), want)
+		t.FailNow()
+	}
+
+	got, err := AESGCMDecrypt(key, nonce, c, ad, tag)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !bytes.Equal(got, plain) {
+		t.Logf("got: %s, want: %s", got, plain)
+		t.FailNow()
+	}
+
+	c[0] ^= 1
+	if _, err := AESGCMDecrypt(key, nonce, c, ad, tag); err == nil {
+		t.Log("tampered cipher text accepted")
+		t.FailNow()
+	}
+
+	if _, _, err := AESGCMEncrypt(key[:8], nonce, plain, ad); !errors.Is(err, ErrKeySize) {
+		t.Logf("got: %v, want: %v", err, ErrKeySize)
+		t.FailNow()
+	}
+}
+
+func TestGFPolyRoots(t *testing.T) {
+	var elems []GF128
+	for i := 0; i < 3; i++ {
+		b := make([]byte, 16)
+		b[i] = byte(i + 1)
+		b[15] = 0x42
+		e, err := GF128FromBlock(b)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		elems = append(elems, e)
+	}
+
+	if got := elems[0].Mul(elems[0].Inv()); got != (GF128{lo: 1}) {
+		t.Logf("a * a^-1 = %x", got.Block())
+		t.FailNow()
+	}
+
+	// (y + a)(y + b)(y + c)^2
+	p := GFPoly{{lo: 1}}
+	for _, e := range append(elems, elems[2]) {
+		p = p.Mul(GFPoly{e, {lo: 1}})
+	}
+
+	roots, err := p.Roots()
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if len(roots) != len(elems) {
+		t.Logf("got %d roots, want %d", len(roots), len(elems))
+		t.FailNow()
+	}
+	for _, e := range elems {
+		found := false
+		for _, r := range roots {
+			found = found || r == e
+		}
+		if !found {
+			t.Logf("root %x not found", e.Block())
+			t.FailNow()
+		}
+	}
+
+	// Every element is a root of the zero polynomial
+	if _, err := (GFPoly{}).Roots(); err == nil {
+		t.Log("factored the zero polynomial")
+		t.FailNow()
+	}
+}
+
+func TestProblem63(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	nonce := []byte("123456789012")
+
+	var msgs []*GCMMessage
+	for _, p := range []string{"attack at dawn, bring the cannons", "retreat at dusk and hide the gold"} {
+		ad := []byte("header")
+		c, tag, err := AESGCMEncrypt(key, nonce, []byte(p), ad)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		msgs = append(msgs, &GCMMessage{ad, c, tag})
+	}
+
+	candidates, err := GCMForbiddenAttack(msgs[0], msgs[1])
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// Flip the cipher text and see which candidate gets a tag past
+	// the receiver
+	forged := append([]byte{}, msgs[0].Cipher...)
+	forged[0] ^= 'a' ^ 'd'
+
+	for _, h := range candidates {
+		tag, err := GCMForge(h, msgs[0], msgs[0].AD, forged)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if p, err := AESGCMDecrypt(key, nonce, forged, msgs[0].AD, tag); err == nil {
+			t.Logf("forged: %s", p)
+			return
+		}
+	}
+
+	t.Logf("none of the %d candidates forges a valid tag", len(candidates))
+	t.FailNow()
+}
+
+func TestGF128FromBlock(t *testing.T) {
+	for _, b := range [][]byte{nil, []byte("YELLOW SUBMARIN"), []byte("YELLOW SUBMARINES")} {
+		if _, err := GF128FromBlock(b); err == nil {
+			t.Logf("read a %d byte block", len(b))
+			t.FailNow()
+		}
+	}
+
+	// A truncated tag is no GCM tag
+	msg := &GCMMessage{Cipher: []byte("attack at dawn"), Tag: make([]byte, 8)}
+	if _, err := GCMForbiddenAttack(msg, msg); err == nil {
+		t.Log("took a truncated tag")
+		t.FailNow()
+	}
+	if _, err := GCMForge(GF128{}, msg, nil, msg.Cipher); err == nil {
+		t.Log("took a truncated tag")
+		t.FailNow()
+	}
+}
+
+func TestGF2Matrix(t *testing.T) {
+	a := gf128FromBlock([]byte("YELLOW SUBMARINE"))
+	y := gf128FromBlock([]byte("0123456789abcdef"))
+
+	if got, want := GF128MulMatrix(a).MulGF128(y), a.Mul(y); got != want {
+		t.Logf("got: %v, want: %v", got, want)
+		t.FailNow()
+	}
+	if got, want := GF128SquareMatrix().MulGF128(y), y.Mul(y); got != want {
+		t.Logf("got: %v, want: %v", got, want)
+		t.FailNow()
+	}
+
+	// Multiplying by a is invertible, its rank is full
+	if r := GF128MulMatrix(a).Rank(); r != 128 {
+		t.Logf("got: %d, want: 128", r)
+		t.FailNow()
+	}
+
+	m := NewGF2Matrix(3, 5)
+	for _, e := range [][2]int{{0, 0}, {0, 2}, {1, 1}, {1, 2}, {2, 0}, {2, 1}, {2, 4}} {
+		m.SetBit(e[0], e[1], 1)
+	}
+	k := m.Kernel()
+	if k.Rows != 5-m.Rank() {
+		t.Logf("got: %d, want: %d", k.Rows, 5-m.Rank())
+		t.FailNow()
+	}
+	z := m.Mul(k.Transpose())
+	for i := 0; i < z.Rows; i++ {
+		for j := 0; j < z.Cols; j++ {
+			if z.Bit(i, j) != 0 {
+				t.Logf("kernel vector %d is not sent to zero", j)
+				t.FailNow()
+			}
+		}
+	}
+}
+
+func TestProblem64(t *testing.T) {
+	key := []byte("YELLOW SUBMARINE")
+	nonce := []byte("123456789012")
+
+	// The challenge uses 2^17 blocks and 32 bit tags, which takes
+	// about 2^16 forgeries to get going: the default run scales both
+	// down so that one in 2^8 gets through
+	n, tagSize := 9, 2
+	if *full {
+		n, tagSize = 17, 4
+	}
+
+	plain := bytes.Repeat([]byte("A"), 16<<uint(n))
+	c, tag, err := AESGCMEncryptTruncated(key, nonce, plain, nil, tagSize)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	got, err := GCMTruncatedMACAttack(NewTruncatedGCMVerifier(key, nonce), nil, c, tag, n)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	h := make([]byte, 16)
+	block.Encrypt(h, h)
+
+	if want := gf128FromBlock(h); got != want {
+		t.Logf("got: %v, want: %v", got, want)
+		t.FailNow()
+	}
+}
+
+func TestChaCha20(t *testing.T) {
+	// RFC 8439, 2.4.2
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce := []byte{0, 0, 0, 0, 0, 0, 0, 0x4a, 0, 0, 0, 0}
+	plain := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+
+	want, err := hex.DecodeString("6e2e359a2568f98041ba0728dd0d6981e97e7aec1d4360c20a27afccfd9fae0b" +
+		"f91b65c5524733ab8f593dabcd62b3571639d624e65152ab8f530c359f0861d8" +
+		"07ca0dbf500d6a6156a38e088a22b65e52bc514d16ccf806818ce91ab7793736" +
+		"5af90bbf74a35be6b40b8eedf2785e42874d")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	got, err := ChaCha20Encrypt(plain, key, nonce)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Logf("got: %x, want: %x", got, want)
+		t.FailNow()
+	}
+}
+
+func TestPoly1305(t *testing.T) {
+	// RFC 8439, 2.5.2
+	key, err := hex.DecodeString("85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	want, err := hex.DecodeString("a8061dc1305136c6c22b8baf0c0127a9")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	got, err := Poly1305([]byte("Cryptographic Forum Research Group"), key)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Logf("got: %x, want: %x", got, want)
+		t.FailNow()
+	}
+}
+
+func TestChaCha20Poly1305(t *testing.T) {
+	// RFC 8439, 2.8.2
+	key, err := hex.DecodeString("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	nonce, err := hex.DecodeString("070000004041424344454647")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	ad, err := hex.DecodeString("50515253c0c1c2c3c4c5c6c7")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	want, err := hex.DecodeString("1ae10b594f09e26a7e902ecbd0600691")
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	plain := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+
+	c, tag, err := ChaCha20Poly1305Encrypt(key, nonce, plain, ad)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if !bytes.Equal(tag, want) {
+		t.Logf("got: %x, want: %x", tag, want)
+		t.FailNow()
+	}
+
+	got, err := ChaCha20Poly1305Decrypt(key, nonce, c, ad, tag)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !bytes.Equal(got, plain) {
+		t.Logf("got: %q, want: %q", got, plain)
+		t.FailNow()
+	}
+
+	c[0] ^= 1
+	if _, err := ChaCha20Poly1305Decrypt(key, nonce, c, ad, tag); err == nil {
+		t.Log("tampered message authenticated")
+		t.FailNow()
+	}
+}
+
+func TestFpPoly(t *testing.T) {
+	fp := func(cs ...int64) FpPoly {
+		p := make(FpPoly, len(cs))
+		for i, c := range cs {
+			p[i] = new(big.Int).Mod(big.NewInt(c), poly1305P)
+		}
+		return p
+	}
+
+	// (x - 1)(x - 2) and (x - 1)(x - 3)
+	a, b := fp(2, -3, 1), fp(3, -4, 1)
+	if g := a.Gcd(b); g.Degree() != 1 || g.Sub(fp(-1, 1)).Degree() != -1 {
+		t.Logf("got: %v, want: x - 1", g)
+		t.FailNow()
+	}
+
+	// x^4 mod (x^2 + 1) = 1
+	r, err := fp(0, 1).PowMod(big.NewInt(4), fp(1, 0, 1))
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !r.isOne() {
+		t.Logf("got: %v, want: 1", r)
+		t.FailNow()
+	}
+
+	if _, _, err := a.DivMod(FpPoly{}); err == nil {
+		t.Log("division by the zero polynomial")
+		t.FailNow()
+	}
+	if _, err := a.PowMod(big.NewInt(2), fp(0)); err == nil {
+		t.Log("reduction modulo the zero polynomial")
+		t.FailNow()
+	}
+}
+
+func TestProblem65(t *testing.T) {
+	key := []byte("YELLOW SUBMARINEYELLOW SUBMARINE")
+	nonce := []byte("123456789012")
+
+	var msgs []*GCMMessage
+	for _, p := range []string{"attack at dawn, bring the cannons", "retreat at dusk and hide the gold"} {
+		ad := []byte("header")
+		c, tag, err := ChaCha20Poly1305Encrypt(key, nonce, []byte(p), ad)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		msgs = append(msgs, &GCMMessage{ad, c, tag})
+	}
+
+	keys, err := ChaCha20Poly1305NonceReuseAttack(msgs[0], msgs[1])
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	forged := append([]byte{}, msgs[0].Cipher...)
+	forged[0] ^= 'a' ^ 'd'
+
+	for _, k := range keys {
+		tag, err := ChaCha20Poly1305Forge(k, msgs[0].AD, forged)
+		if err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+		if p, err := ChaCha20Poly1305Decrypt(key, nonce, forged, msgs[0].AD, tag); err == nil {
+			t.Logf("forged: %s", p)
+			return
+		}
+	}
+
+	t.Logf("none of the %d candidates forges a valid tag", len(keys))
+	t.FailNow()
+}